@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Lists an audit sorts every file of the archive into
+const (
+	auditMatched          = "matched"
+	auditMediaWithoutJSON = "media-without-json"
+	auditJSONWithoutMedia = "json-without-media"
+)
+
+// auditEntry pairs a media file with its sidecar. One side is empty for
+// orphans.
+type auditEntry struct {
+	Status    string `json:"status"`
+	MediaFile string `json:"media_file,omitempty"`
+	JSONFile  string `json:"json_file,omitempty"`
+	Match     string `json:"match,omitempty"` // strategy that found the media file
+	Detail    string `json:"detail,omitempty"`
+}
+
+// readPhotoMetadata decodes a JSON sidecar
+func readPhotoMetadata(jsonPath string) (photoMetadata, error) {
+	var meta photoMetadata
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// buildAudit pairs every media file under sourceDir with its sidecar the
+// same way update and sort do. Matched pairs come first in walk order,
+// followed by media without JSON and JSON without media.
+func buildAudit(sourceDir string, matcher *mediaMatcher) ([]auditEntry, error) {
+	var jsonFiles, mediaFiles []string
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Warning: Skipping path due to error: %s: %v", path, err)
+			return nil
+		}
+		switch {
+		case info.IsDir():
+		case filepath.Ext(path) == ".json":
+			if info.Name() != "metadata.json" {
+				jsonFiles = append(jsonFiles, path)
+			}
+		case isMediaFile(info.Name()):
+			mediaFiles = append(mediaFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matched, orphanJSON []auditEntry
+	claimed := make(map[string]bool)
+	for _, jsonPath := range jsonFiles {
+		// A sidecar that cannot be decoded may still match by its name
+		var detail string
+		meta, err := readPhotoMetadata(jsonPath)
+		if err != nil {
+			detail = fmt.Sprintf("unreadable JSON: %v", err)
+		}
+
+		mediaPath, match := matcher.find(jsonPath, meta)
+		if mediaPath == "" {
+			if detail == "" {
+				detail = fmt.Sprintf("media file %q not found", sidecarMediaName(filepath.Base(jsonPath)))
+			}
+			orphanJSON = append(orphanJSON, auditEntry{Status: auditJSONWithoutMedia, JSONFile: jsonPath, Detail: detail})
+			continue
+		}
+
+		matched = append(matched, auditEntry{Status: auditMatched, MediaFile: mediaPath, JSONFile: jsonPath, Match: match, Detail: detail})
+		claimed[mediaPath] = true
+		for _, variant := range matcher.editedVariants(mediaPath) {
+			matched = append(matched, auditEntry{Status: auditMatched, MediaFile: variant, JSONFile: jsonPath, Match: matchEditedVariant})
+			claimed[variant] = true
+		}
+		if companion := matcher.liveCompanion(mediaPath); companion != "" {
+			matched = append(matched, auditEntry{Status: auditMatched, MediaFile: companion, JSONFile: jsonPath, Match: matchLiveCompanion})
+			claimed[companion] = true
+		}
+	}
+
+	var orphanMedia []auditEntry
+	for _, mediaPath := range mediaFiles {
+		if !claimed[mediaPath] {
+			orphanMedia = append(orphanMedia, auditEntry{Status: auditMediaWithoutJSON, MediaFile: mediaPath})
+		}
+	}
+	sort.Slice(orphanMedia, func(i, j int) bool { return orphanMedia[i].MediaFile < orphanMedia[j].MediaFile })
+
+	entries := append(matched, orphanMedia...)
+	return append(entries, orphanJSON...), nil
+}
+
+// writeAuditCSV saves the audit with one row per entry
+func writeAuditCSV(path string, entries []auditEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"status", "media_file", "json_file", "match", "detail"})
+	for _, e := range entries {
+		w.Write([]string{e.Status, e.MediaFile, e.JSONFile, e.Match, e.Detail})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeAuditJSON saves the audit as an indented JSON document with the
+// three lists kept apart
+func writeAuditJSON(path, sourceDir string, entries []auditEntry) error {
+	lists := map[string][]auditEntry{
+		auditMatched:          {},
+		auditMediaWithoutJSON: {},
+		auditJSONWithoutMedia: {},
+	}
+	for _, e := range entries {
+		lists[e.Status] = append(lists[e.Status], e)
+	}
+
+	data, err := json.MarshalIndent(struct {
+		SourceDirectory  string       `json:"source_directory"`
+		Generated        time.Time    `json:"generated"`
+		Matched          []auditEntry `json:"matched"`
+		MediaWithoutJSON []auditEntry `json:"media_without_json"`
+		JSONWithoutMedia []auditEntry `json:"json_without_media"`
+	}{sourceDir, time.Now(), lists[auditMatched], lists[auditMediaWithoutJSON], lists[auditJSONWithoutMedia]}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func performAudit(sourceDir string, matcher *mediaMatcher) {
+	fmt.Printf("AUDIT MODE: Pairing media files with their JSON metadata in %s\n", sourceDir)
+
+	entries, err := buildAudit(sourceDir, matcher)
+	if err != nil {
+		log.Fatalf("Error scanning directory: %v", err)
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	csvName := fmt.Sprintf("audit_%s.csv", timestamp)
+	jsonName := fmt.Sprintf("audit_%s.json", timestamp)
+	if err := writeAuditCSV(csvName, entries); err != nil {
+		log.Fatalf("Error writing audit CSV: %v", err)
+	}
+	if err := writeAuditJSON(jsonName, sourceDir, entries); err != nil {
+		log.Fatalf("Error writing audit JSON: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Status]++
+	}
+
+	fmt.Println()
+	fmt.Println("=== AUDIT RESULTS ===")
+	fmt.Printf("Matched media files: %d\n", counts[auditMatched])
+	fmt.Printf("Media files without JSON: %d\n", counts[auditMediaWithoutJSON])
+	fmt.Printf("JSON files without media: %d\n", counts[auditJSONWithoutMedia])
+	fmt.Printf("Audit written to %s and %s\n", csvName, jsonName)
+}
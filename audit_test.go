@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildAudit(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"IMG_0001.jpg": "image",
+		"IMG_0001.jpg.supplemental-metadata.json": `{"title": "IMG_0001.jpg"}`,
+		"IMG_0001-edited.jpg":                     "edited",
+		"IMG_0002.HEIC":                           "image",
+		"IMG_0002.MOV":                            "video",
+		"IMG_0002.HEIC.json":                      `{"title": "IMG_0002.HEIC"}`,
+		"IMG_0003.jpg":                            "no sidecar",
+		"IMG_0004.jpg.json":                       `{"title": "IMG_0004.jpg"}`,
+		"metadata.json":                           `{"title": "Album"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	entries, err := buildAudit(dir, newMediaMatcher(defaultEditedSuffixes))
+	if err != nil {
+		t.Fatalf("buildAudit() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, e := range entries {
+		key := filepath.Base(e.MediaFile)
+		if e.MediaFile == "" {
+			key = filepath.Base(e.JSONFile)
+		}
+		got[key] = e.Status
+	}
+	want := map[string]string{
+		"IMG_0001.jpg":        auditMatched,
+		"IMG_0001-edited.jpg": auditMatched,
+		"IMG_0002.HEIC":       auditMatched,
+		"IMG_0002.MOV":        auditMatched,
+		"IMG_0003.jpg":        auditMediaWithoutJSON,
+		"IMG_0004.jpg.json":   auditJSONWithoutMedia,
+	}
+	if len(got) != len(want) {
+		t.Errorf("buildAudit() = %v, want %v", got, want)
+	}
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("buildAudit() status of %s = %q, want %q", name, got[name], status)
+		}
+	}
+
+	csvPath := filepath.Join(t.TempDir(), "audit.csv")
+	if err := writeAuditCSV(csvPath, entries); err != nil {
+		t.Fatalf("writeAuditCSV() error = %v", err)
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to open CSV: %v", err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV is not valid: %v", err)
+	}
+	if len(rows) != len(entries)+1 || rows[0][0] != "status" {
+		t.Errorf("CSV has %d rows, want a header and %d entries", len(rows), len(entries))
+	}
+
+	jsonPath := filepath.Join(t.TempDir(), "audit.json")
+	if err := writeAuditJSON(jsonPath, dir, entries); err != nil {
+		t.Fatalf("writeAuditJSON() error = %v", err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read JSON: %v", err)
+	}
+	var decoded struct {
+		Matched          []auditEntry `json:"matched"`
+		MediaWithoutJSON []auditEntry `json:"media_without_json"`
+		JSONWithoutMedia []auditEntry `json:"json_without_media"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Audit is not valid JSON: %v", err)
+	}
+	if len(decoded.Matched) != 4 || len(decoded.MediaWithoutJSON) != 1 || len(decoded.JSONWithoutMedia) != 1 {
+		t.Errorf("audit lists = %d/%d/%d, want 4/1/1", len(decoded.Matched), len(decoded.MediaWithoutJSON), len(decoded.JSONWithoutMedia))
+	}
+}
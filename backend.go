@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// MetadataBackend reads and writes media file metadata. Tag names follow
+// exiftool's naming so backends are interchangeable.
+type MetadataBackend interface {
+	ReadTags(filePath string, tags ...string) (map[string]any, error)
+	WriteTags(filePath string, tags map[string]string) error
+	Close() error
+}
+
+// backendFactory creates a backend for a single worker
+type backendFactory func() (MetadataBackend, error)
+
+// newBackendFactory returns the factory for an engine selectable with the
+// -backend flag. Timeout and retries apply to exiftool processes.
+func newBackendFactory(name string, timeout time.Duration, retries int) (backendFactory, error) {
+	switch name {
+	case "auto":
+		return func() (MetadataBackend, error) { return newAutoBackend(timeout, retries) }, nil
+	case "native":
+		return func() (MetadataBackend, error) { return nativeBackend{}, nil }, nil
+	case "exiftool":
+		return func() (MetadataBackend, error) { return NewExifTool(timeout, retries) }, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", name)
+}
+
+// newAutoBackend uses the built-in reader and writer, falling back to
+// exiftool for other formats when it is installed
+func newAutoBackend(timeout time.Duration, retries int) (MetadataBackend, error) {
+	if _, err := exec.LookPath("exiftool"); err != nil {
+		return nativeBackend{}, nil
+	}
+
+	et, err := NewExifTool(timeout, retries)
+	if err != nil {
+		return nil, err
+	}
+	return chainBackend{nativeBackend{}, et}, nil
+}
+
+// batchBackend is implemented by backends that can handle several files
+// per round-trip. Results and errors are returned per file, in input order.
+type batchBackend interface {
+	ReadTagsBatch(filePaths []string, tags ...string) ([]map[string]any, []error)
+	WriteTagsBatch(filePaths []string, tags []map[string]string) []error
+}
+
+// readTagsBatch reads tags of several files, batching when the backend supports it
+func readTagsBatch(backend MetadataBackend, filePaths []string, tags ...string) ([]map[string]any, []error) {
+	if bb, ok := backend.(batchBackend); ok {
+		return bb.ReadTagsBatch(filePaths, tags...)
+	}
+
+	results := make([]map[string]any, len(filePaths))
+	errs := make([]error, len(filePaths))
+	for i, filePath := range filePaths {
+		results[i], errs[i] = backend.ReadTags(filePath, tags...)
+	}
+	return results, errs
+}
+
+// writeTagsBatch writes tags to several files, batching when the backend supports it
+func writeTagsBatch(backend MetadataBackend, filePaths []string, tags []map[string]string) []error {
+	if bb, ok := backend.(batchBackend); ok {
+		return bb.WriteTagsBatch(filePaths, tags)
+	}
+
+	errs := make([]error, len(filePaths))
+	for i, filePath := range filePaths {
+		errs[i] = backend.WriteTags(filePath, tags[i])
+	}
+	return errs
+}
+
+// fileMessage is a warning or error a backend reported for a file
+type fileMessage struct {
+	Level   string `json:"level"` // "warning" or "error"
+	Message string `json:"message"`
+}
+
+// messageBackend is implemented by backends that report warnings and errors
+// per file alongside their results
+type messageBackend interface {
+	TakeMessages(filePath string) []fileMessage
+}
+
+// takeMessages returns the messages collected for a file since the last call
+func takeMessages(backend MetadataBackend, filePath string) []fileMessage {
+	if mb, ok := backend.(messageBackend); ok {
+		return mb.TakeMessages(filePath)
+	}
+	return nil
+}
+
+// nativeBackend reads and writes metadata without external tools
+type nativeBackend struct{}
+
+// ReadTags reads date tags only. Asking for any other tag gives
+// errUnsupportedTag, so a chain reads the file with the next backend
+// instead of reporting the tag missing.
+func (nativeBackend) ReadTags(filePath string, tags ...string) (map[string]any, error) {
+	for _, tag := range tags {
+		if !slices.Contains(timestampTags, tag) {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedTag, tag)
+		}
+	}
+
+	dates, err := nativeReader{}.ReadDates(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]any)
+	for _, tag := range tags {
+		if value, ok := dates[tag]; ok {
+			values[tag] = value
+		}
+	}
+	return values, nil
+}
+
+func (nativeBackend) WriteTags(filePath string, tags map[string]string) error {
+	err := writeJPEGTags(filePath, tags)
+	if errors.Is(err, errUnsupportedFormat) {
+		return writeMatroskaTags(filePath, tags)
+	}
+	return err
+}
+
+func (nativeBackend) Close() error {
+	return nil
+}
+
+// chainBackend tries each backend in turn until one of them succeeds
+type chainBackend []MetadataBackend
+
+func (cb chainBackend) ReadTags(filePath string, tags ...string) (map[string]any, error) {
+	err := errUnsupportedFormat
+	for _, backend := range cb {
+		var values map[string]any
+		if values, err = backend.ReadTags(filePath, tags...); err == nil {
+			return values, nil
+		}
+	}
+	return nil, err
+}
+
+func (cb chainBackend) WriteTags(filePath string, tags map[string]string) error {
+	err := errUnsupportedFormat
+	for _, backend := range cb {
+		if err = backend.WriteTags(filePath, tags); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ReadTagsBatch hands the files each backend could not read to the next one
+func (cb chainBackend) ReadTagsBatch(filePaths []string, tags ...string) ([]map[string]any, []error) {
+	results := make([]map[string]any, len(filePaths))
+	errs := repeatError(errUnsupportedFormat, len(filePaths))
+
+	pending := make([]int, len(filePaths))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for _, backend := range cb {
+		if len(pending) == 0 {
+			break
+		}
+		batch := make([]string, len(pending))
+		for j, i := range pending {
+			batch[j] = filePaths[i]
+		}
+
+		batchResults, batchErrs := readTagsBatch(backend, batch, tags...)
+		var failed []int
+		for j, i := range pending {
+			results[i], errs[i] = batchResults[j], batchErrs[j]
+			if errs[i] != nil {
+				failed = append(failed, i)
+			}
+		}
+		pending = failed
+	}
+	return results, errs
+}
+
+// WriteTagsBatch hands the files each backend could not write to the next one
+func (cb chainBackend) WriteTagsBatch(filePaths []string, tags []map[string]string) []error {
+	errs := repeatError(errUnsupportedFormat, len(filePaths))
+
+	pending := make([]int, len(filePaths))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for _, backend := range cb {
+		if len(pending) == 0 {
+			break
+		}
+		batch := make([]string, len(pending))
+		batchTags := make([]map[string]string, len(pending))
+		for j, i := range pending {
+			batch[j], batchTags[j] = filePaths[i], tags[i]
+		}
+
+		batchErrs := writeTagsBatch(backend, batch, batchTags)
+		var failed []int
+		for j, i := range pending {
+			if errs[i] = batchErrs[j]; errs[i] != nil {
+				failed = append(failed, i)
+			}
+		}
+		pending = failed
+	}
+	return errs
+}
+
+// TakeMessages collects the messages every backend in the chain reported
+func (cb chainBackend) TakeMessages(filePath string) []fileMessage {
+	var messages []fileMessage
+	for _, backend := range cb {
+		messages = append(messages, takeMessages(backend, filePath)...)
+	}
+	return messages
+}
+
+func (cb chainBackend) Close() error {
+	var firstErr error
+	for _, backend := range cb {
+		if err := backend.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
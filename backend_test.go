@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeBackend is an in-memory MetadataBackend keyed by file path
+type fakeBackend struct {
+	mu     sync.Mutex
+	files  map[string]map[string]string
+	writes int
+	err    error
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{files: make(map[string]map[string]string)}
+}
+
+func (fb *fakeBackend) factory() backendFactory {
+	return func() (MetadataBackend, error) { return fb, nil }
+}
+
+func (fb *fakeBackend) ReadTags(filePath string, tags ...string) (map[string]any, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if fb.err != nil {
+		return nil, fb.err
+	}
+	values := make(map[string]any)
+	for _, tag := range tags {
+		if value, ok := fb.files[filePath][tag]; ok {
+			values[tag] = value
+		}
+	}
+	return values, nil
+}
+
+func (fb *fakeBackend) WriteTags(filePath string, tags map[string]string) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if fb.err != nil {
+		return fb.err
+	}
+	if fb.files[filePath] == nil {
+		fb.files[filePath] = make(map[string]string)
+	}
+	for name, value := range tags {
+		fb.files[filePath][name] = value
+	}
+	fb.writes++
+	return nil
+}
+
+func (fb *fakeBackend) Close() error {
+	return nil
+}
+
+func TestChainBackend(t *testing.T) {
+	failing := newFakeBackend()
+	failing.err = errUnsupportedFormat
+	working := newFakeBackend()
+	working.files["video.avi"] = map[string]string{"CreateDate": "2020:01:01 00:00:00"}
+
+	chain := chainBackend{failing, working}
+	values, err := chain.ReadTags("video.avi", "CreateDate")
+	if err != nil {
+		t.Fatalf("ReadTags() error = %v", err)
+	}
+	if values["CreateDate"] != "2020:01:01 00:00:00" {
+		t.Errorf("ReadTags() = %v, want fallback result", values)
+	}
+
+	if err := chain.WriteTags("video.avi", map[string]string{"DateTimeOriginal": "x"}); err != nil {
+		t.Fatalf("WriteTags() error = %v", err)
+	}
+	if working.writes != 1 {
+		t.Errorf("fallback backend writes = %d, want 1", working.writes)
+	}
+
+	if _, err := (chainBackend{}).ReadTags("video.avi"); !errors.Is(err, errUnsupportedFormat) {
+		t.Errorf("empty chainBackend error = %v, want errUnsupportedFormat", err)
+	}
+}
+
+func TestChainBackend_Batch(t *testing.T) {
+	native := newFakeBackend()
+	native.files["a.jpg"] = map[string]string{"DateTimeOriginal": "2020:01:01 00:00:00"}
+	fallback := newFakeBackend()
+	fallback.files["b.avi"] = map[string]string{"CreateDate": "2021:01:01 00:00:00"}
+
+	// The first backend only knows a.jpg, the second only b.avi
+	chain := chainBackend{&pathBackend{native, "a.jpg"}, &pathBackend{fallback, "b.avi"}}
+	results, errs := readTagsBatch(chain, []string{"a.jpg", "b.avi", "c.gif"}, "DateTimeOriginal", "CreateDate")
+
+	if errs[0] != nil || results[0]["DateTimeOriginal"] != "2020:01:01 00:00:00" {
+		t.Errorf("a.jpg = %v, %v; want native result", results[0], errs[0])
+	}
+	if errs[1] != nil || results[1]["CreateDate"] != "2021:01:01 00:00:00" {
+		t.Errorf("b.avi = %v, %v; want fallback result", results[1], errs[1])
+	}
+	if errs[2] == nil {
+		t.Error("c.gif error = nil, want an error from the last backend")
+	}
+
+	writeErrs := writeTagsBatch(chain, []string{"a.jpg", "b.avi"}, []map[string]string{{"X": "1"}, {"Y": "2"}})
+	if writeErrs[0] != nil || writeErrs[1] != nil {
+		t.Errorf("writeTagsBatch() errors = %v, want none", writeErrs)
+	}
+	if native.files["a.jpg"]["X"] != "1" || fallback.files["b.avi"]["Y"] != "2" {
+		t.Error("writeTagsBatch() did not route each file to the backend that supports it")
+	}
+}
+
+// pathBackend only accepts a single path, rejecting everything else
+type pathBackend struct {
+	*fakeBackend
+	path string
+}
+
+func (pb *pathBackend) ReadTags(filePath string, tags ...string) (map[string]any, error) {
+	if filePath != pb.path {
+		return nil, errUnsupportedFormat
+	}
+	return pb.fakeBackend.ReadTags(filePath, tags...)
+}
+
+func (pb *pathBackend) WriteTags(filePath string, tags map[string]string) error {
+	if filePath != pb.path {
+		return errUnsupportedFormat
+	}
+	return pb.fakeBackend.WriteTags(filePath, tags)
+}
+
+func TestNativeBackend_ReadTags(t *testing.T) {
+	values, err := nativeBackend{}.ReadTags("test/20170608_194241.jpg", "DateTimeOriginal")
+	if err != nil {
+		t.Fatalf("ReadTags() error = %v", err)
+	}
+	if len(values) != 1 || values["DateTimeOriginal"] != "2017:06:08 19:42:41" {
+		t.Errorf("ReadTags() = %v, want only DateTimeOriginal", values)
+	}
+
+	// Tags it cannot read fail rather than come back missing
+	if _, err := (nativeBackend{}).ReadTags("test/20170608_194241.jpg", "DateTimeOriginal", "GPSLatitude"); !errors.Is(err, errUnsupportedTag) {
+		t.Errorf("ReadTags(GPSLatitude) error = %v, want %v", err, errUnsupportedTag)
+	}
+}
+
+func TestIsMissingTimestamps(t *testing.T) {
+	tests := []struct {
+		name  string
+		dates map[string]string
+		want  bool
+	}{
+		{"date present", map[string]string{"DateTimeOriginal": "2017:06:08 19:42:41"}, false},
+		{"zero date", map[string]string{"CreateDate": "0000:00:00 00:00:00"}, true},
+		{"no dates", map[string]string{}, true},
+		{"unrelated tag", map[string]string{"ModifyDate": "2017:06:08 19:42:41"}, true},
+		{"unparseable date", map[string]string{"CreateDate": "    :  :     :  :  "}, true},
+		{"warning text", map[string]string{"DateTimeOriginal": "Warning: bad format"}, true},
+		{"date with offset", map[string]string{"CreationDate": "2017:06:08 19:42:41.123-04:00"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newFakeBackend()
+			backend.files["file.jpg"] = tt.dates
+			if got := isMissingTimestamps(backend, "file.jpg"); got != tt.want {
+				t.Errorf("isMissingTimestamps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanWorker(t *testing.T) {
+	backend := newFakeBackend()
+	backend.files["dated.jpg"] = map[string]string{"DateTimeOriginal": "2017:06:08 19:42:41"}
+
+	jobs := make(chan string, 2)
+	results := make(chan scanResult, 2)
+	jobs <- "dated.jpg"
+	jobs <- "undated.jpg"
+	close(jobs)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	scanWorker(1, &wg, jobs, results, newProgressBar(2), backend.factory(), 16)
+	close(results)
+
+	missing := make(map[string]bool)
+	for result := range results {
+		missing[result.filePath] = result.missing
+	}
+	if missing["dated.jpg"] || !missing["undated.jpg"] {
+		t.Errorf("scanWorker() results = %v, want only undated.jpg missing", missing)
+	}
+}
+
+// writeUpdateFixture creates the media files named in a fresh directory
+// with the test sidecar of 20170608_194241.jpg next to them and returns the
+// directory and the sidecar's path
+func writeUpdateFixture(t *testing.T, mediaNames ...string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range mediaNames {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("image"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	sidecar, err := os.ReadFile("test/20170608_194241.jpg.supplemental-metadata.json")
+	if err != nil {
+		t.Fatalf("Failed to read sidecar: %v", err)
+	}
+	jsonPath := filepath.Join(dir, "20170608_194241.jpg.supplemental-metadata.json")
+	if err := os.WriteFile(jsonPath, sidecar, 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+	return dir, jsonPath
+}
+
+// runUpdateWorker runs a single update worker over the sidecars and returns
+// its report and the number of files it updated. Options left unset get a
+// batch size of 16 and a matcher without edited suffixes.
+func runUpdateWorker(opts updateOptions, jl *journal, jsonPaths ...string) (*updateReport, int64) {
+	if opts.batchSize == 0 {
+		opts.batchSize = 16
+	}
+	if opts.matcher == nil {
+		opts.matcher = newMediaMatcher(nil)
+	}
+	jobs := make(chan string, len(jsonPaths))
+	for _, jsonPath := range jsonPaths {
+		jobs <- jsonPath
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	var updated int64
+	report := &updateReport{}
+	wg.Add(1)
+	updateWorker(1, &wg, jobs, opts, newProgressBar(len(jsonPaths)), &updated, report, jl)
+	return report, updated
+}
+
+func TestUpdateWorker(t *testing.T) {
+	dir, jsonPath := writeUpdateFixture(t, "20170608_194241.jpg")
+	imagePath := filepath.Join(dir, "20170608_194241.jpg")
+
+	backend := newFakeBackend()
+	report, updated := runUpdateWorker(updateOptions{newBackend: backend.factory()}, nil, jsonPath)
+
+	if updated != 1 {
+		t.Errorf("updated files = %d, want 1", updated)
+	}
+	if len(report.entries) != 1 || report.entries[0].Status != statusUpdated || report.entries[0].MediaFile != imagePath {
+		t.Errorf("report entries = %+v, want one updated entry for %s", report.entries, imagePath)
+	}
+
+	tags := backend.files[imagePath]
+	// The sidecar's coordinates are in New York, four hours behind UTC in June
+	if tags["DateTimeOriginal"] != "2017:06:08 19:42:41" || tags["OffsetTimeOriginal"] != "-04:00" {
+		t.Errorf("DateTimeOriginal = %q %q, want 2017:06:08 19:42:41 -04:00", tags["DateTimeOriginal"], tags["OffsetTimeOriginal"])
+	}
+	if tags["GPSLatitude"] != "40.733437" || tags["GPSLongitudeRef"] != "W" || tags["GPSAltitude"] != "11.199000" || tags["GPSAltitudeRef"] != "1" {
+		t.Errorf("GPS tags = %v, want sidecar coordinates", tags)
+	}
+	if _, err := os.Stat(jsonPath); !os.IsNotExist(err) {
+		t.Error("updateWorker() did not remove the JSON sidecar")
+	}
+}
+
+func TestUpdateWorker_WriteFailure(t *testing.T) {
+	_, jsonPath := writeUpdateFixture(t, "20170608_194241.jpg")
+
+	backend := &messageFakeBackend{newFakeBackend()}
+	newBackend := func() (MetadataBackend, error) { return backend, nil }
+	report, updated := runUpdateWorker(updateOptions{newBackend: newBackend}, nil, jsonPath)
+
+	if updated != 0 {
+		t.Errorf("updated files = %d, want 0 for a refused write", updated)
+	}
+	if len(report.entries) != 1 {
+		t.Fatalf("report entries = %+v, want one", report.entries)
+	}
+	entry := report.entries[0]
+	if entry.Status != statusFailed || entry.Detail != "Not a valid JPG (looks more like a PNG)" {
+		t.Errorf("report entry = %+v, want failed with exiftool's reason", entry)
+	}
+	if len(entry.Messages) != 1 || entry.Messages[0].Level != "error" {
+		t.Errorf("report messages = %+v, want the exiftool error", entry.Messages)
+	}
+	if _, err := os.Stat(jsonPath); err != nil {
+		t.Error("updateWorker() removed the JSON sidecar of a failed file")
+	}
+}
+
+func TestUpdateWorker_EditedVariants(t *testing.T) {
+	dir, jsonPath := writeUpdateFixture(t, "20170608_194241.jpg", "20170608_194241-edited.jpg")
+	imagePath := filepath.Join(dir, "20170608_194241.jpg")
+	editedPath := filepath.Join(dir, "20170608_194241-edited.jpg")
+
+	backend := newFakeBackend()
+	opts := updateOptions{newBackend: backend.factory(), matcher: newMediaMatcher(defaultEditedSuffixes)}
+	report, updated := runUpdateWorker(opts, nil, jsonPath)
+
+	if updated != 2 {
+		t.Errorf("updated files = %d, want 2", updated)
+	}
+	if len(report.entries) != 2 || report.entries[1].MediaFile != editedPath || report.entries[1].Match != matchEditedVariant {
+		t.Errorf("report entries = %+v, want the edited copy as a second entry", report.entries)
+	}
+	if backend.files[editedPath]["DateTimeOriginal"] != backend.files[imagePath]["DateTimeOriginal"] {
+		t.Errorf("edited copy tags = %v, want the original's %v", backend.files[editedPath], backend.files[imagePath])
+	}
+	if _, err := os.Stat(jsonPath); !os.IsNotExist(err) {
+		t.Error("updateWorker() did not remove the JSON sidecar")
+	}
+}
+
+func TestUpdateWorker_UnsupportedContainer(t *testing.T) {
+	dir := t.TempDir()
+	videoPath := filepath.Join(dir, "MVI_0001.AVI")
+	jsonPath := videoPath + ".json"
+	if err := os.WriteFile(videoPath, []byte("video"), 0644); err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	sidecar := `{"title": "MVI_0001.AVI", "photoTakenTime": {"timestamp": "1496965361"}}`
+	if err := os.WriteFile(jsonPath, []byte(sidecar), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+
+	backend := newFakeBackend()
+	report, updated := runUpdateWorker(updateOptions{newBackend: backend.factory()}, nil, jsonPath)
+
+	if updated != 0 || len(backend.files[videoPath]) != 0 {
+		t.Errorf("updated files = %d with tags %v, want the AVI left alone", updated, backend.files[videoPath])
+	}
+	if len(report.entries) != 1 || report.entries[0].Status != statusSkipped || !strings.Contains(report.entries[0].Detail, ".avi") {
+		t.Errorf("report entries = %+v, want the AVI skipped for its container", report.entries)
+	}
+	if _, err := os.Stat(jsonPath); err != nil {
+		t.Error("updateWorker() removed the JSON sidecar of a skipped file")
+	}
+}
+
+func TestUpdateWorker_Journal(t *testing.T) {
+	dir, jsonPath := writeUpdateFixture(t, "20170608_194241.jpg")
+	undated := filepath.Join(dir, "undated.jpg.json")
+	if err := os.WriteFile(undated, []byte(`{"title": "undated.jpg"}`), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+	orphan := filepath.Join(dir, "missing.jpg.json")
+	if err := os.WriteFile(orphan, []byte(`{"title": "missing.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+
+	jl, err := openJournal(dir, "update", false, false)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	defer jl.Close()
+	runUpdateWorker(updateOptions{keepJSON: true, newBackend: newFakeBackend().factory()}, jl, jsonPath, undated, orphan)
+
+	// A sidecar without a timestamp never becomes usable, so it is not
+	// retried, while one whose media file is missing may be on a later run
+	remaining, done, skipped, retry, _ := jl.pending([]string{jsonPath, undated, orphan})
+	if len(remaining) != 1 || remaining[0] != orphan || done != 1 || skipped != 1 || retry != 1 {
+		t.Errorf("pending() = %v with %d done, %d skipped and %d to retry, want only %s left", remaining, done, skipped, retry, orphan)
+	}
+	if got := jl.failed(); got != 1 {
+		t.Errorf("failed() = %d, want 1", got)
+	}
+}
+
+// messageFakeBackend refuses every write the way exiftool refuses a
+// mislabelled file, reporting the reason as a message
+type messageFakeBackend struct {
+	*fakeBackend
+}
+
+func (mb *messageFakeBackend) WriteTags(filePath string, tags map[string]string) error {
+	return errors.New("Not a valid JPG (looks more like a PNG)")
+}
+
+func (mb *messageFakeBackend) TakeMessages(filePath string) []fileMessage {
+	return []fileMessage{{Level: "error", Message: "Not a valid JPG (looks more like a PNG)"}}
+}
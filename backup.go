@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// backupManifestName lists the backed up files inside the backup directory
+const backupManifestName = "manifest.jsonl"
+
+// backupEntry maps a media file to the stored copy of its original
+type backupEntry struct {
+	Path   string    `json:"path"` // relative to the source directory
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+}
+
+// backupStore keeps pristine copies of media files before they are
+// rewritten. Copies are stored once per content hash under objects/ and
+// listed in the manifest by relative path. Only the first backup of a path
+// is kept, so repeated runs never replace the original with an edited copy.
+// A nil store backs up nothing.
+type backupStore struct {
+	mu       sync.Mutex
+	dir      string
+	root     string
+	manifest *os.File
+	saved    map[string]bool
+}
+
+func openBackupStore(dir, sourceDir string) (*backupStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := readBackupManifest(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	saved := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		saved[e.Path] = true
+	}
+
+	manifest, err := os.OpenFile(filepath.Join(dir, backupManifestName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &backupStore{dir: dir, root: sourceDir, manifest: manifest, saved: saved}, nil
+}
+
+// save stores a copy of filePath unless the path was backed up before
+func (b *backupStore) save(filePath string) error {
+	if b == nil {
+		return nil
+	}
+
+	rel, err := filepath.Rel(b.root, filePath)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	b.mu.Lock()
+	done := b.saved[rel]
+	b.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	sum, size, err := b.store(filePath)
+	if err != nil {
+		return fmt.Errorf("backing up %s: %v", filePath, err)
+	}
+
+	data, err := json.Marshal(backupEntry{Path: rel, SHA256: sum, Size: size, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.saved[rel] {
+		return nil
+	}
+	if _, err := b.manifest.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing backup manifest: %v", err)
+	}
+	b.saved[rel] = true
+	return nil
+}
+
+// store copies a file into objects/ and returns its hash and size
+func (b *backupStore) store(filePath string) (string, int64, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(b.dir, "objects"), ".tmp*")
+	if err != nil {
+		return "", 0, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	objectPath := b.objectPath(sum)
+	if _, err := os.Stat(objectPath); err == nil {
+		return sum, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return "", 0, err
+	}
+	return sum, size, os.Rename(tmpPath, objectPath)
+}
+
+func (b *backupStore) objectPath(sum string) string {
+	return filepath.Join(b.dir, "objects", sum[:2], sum)
+}
+
+func (b *backupStore) Close() error {
+	if b == nil {
+		return nil
+	}
+	return b.manifest.Close()
+}
+
+// readBackupManifest returns the first backup of each path in manifest order
+func readBackupManifest(dir string) ([]backupEntry, error) {
+	file, err := os.Open(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []backupEntry
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e backupEntry
+		// A run killed mid-write can leave a partial last line
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Path == "" || len(e.SHA256) < 2 || seen[e.Path] {
+			continue
+		}
+		seen[e.Path] = true
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// hashFile returns the SHA-256 of a file's contents
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// performRestore puts the originals from a backup directory back into the
+// source directory, skipping files that already match their backup
+func performRestore(backupDir, sourceDir string, dryRun bool) {
+	fmt.Printf("RESTORE MODE: Restoring originals from %s into %s...\n", backupDir, sourceDir)
+
+	entries, err := readBackupManifest(backupDir)
+	if err != nil {
+		log.Fatalf("Error reading backup manifest: %v", err)
+	}
+	fmt.Printf("Found %d backed up files\n", len(entries))
+
+	store := &backupStore{dir: backupDir}
+	var restored, unchanged, failed int
+	for _, e := range entries {
+		target := filepath.Join(sourceDir, filepath.FromSlash(e.Path))
+		if sum, err := hashFile(target); err == nil && sum == e.SHA256 {
+			unchanged++
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY RUN] Would restore %s", target)
+			restored++
+			continue
+		}
+		if err := restoreObject(store.objectPath(e.SHA256), e.SHA256, target); err != nil {
+			log.Printf("Could not restore %s: %v", target, err)
+			failed++
+			continue
+		}
+		restored++
+	}
+
+	fmt.Printf("Restore complete! Restored %d files, %d already matched their backup, %d failed.\n", restored, unchanged, failed)
+}
+
+// restoreObject copies a stored original over target after checking that
+// the stored copy is intact
+func restoreObject(objectPath, sum, target string) error {
+	if got, err := hashFile(objectPath); err != nil {
+		return err
+	} else if got != sum {
+		return fmt.Errorf("backup copy %s is corrupt", objectPath)
+	}
+
+	src, err := os.Open(objectPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, target)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
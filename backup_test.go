@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupStore_SaveAndRestore(t *testing.T) {
+	sourceDir := t.TempDir()
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	photo := filepath.Join(sourceDir, "album", "photo.jpg")
+	if err := os.MkdirAll(filepath.Dir(photo), 0755); err != nil {
+		t.Fatalf("Failed to create album: %v", err)
+	}
+	if err := os.WriteFile(photo, []byte("original"), 0640); err != nil {
+		t.Fatalf("Failed to create photo: %v", err)
+	}
+
+	store, err := openBackupStore(backupDir, sourceDir)
+	if err != nil {
+		t.Fatalf("openBackupStore() error = %v", err)
+	}
+	if err := store.save(photo); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	store.Close()
+
+	// A later run must not replace the pristine copy with an edited one
+	if err := os.WriteFile(photo, []byte("edited"), 0640); err != nil {
+		t.Fatalf("Failed to edit photo: %v", err)
+	}
+	store, err = openBackupStore(backupDir, sourceDir)
+	if err != nil {
+		t.Fatalf("openBackupStore() error = %v", err)
+	}
+	if err := store.save(photo); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	store.Close()
+
+	entries, err := readBackupManifest(backupDir)
+	if err != nil {
+		t.Fatalf("readBackupManifest() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "album/photo.jpg" || entries[0].Size != int64(len("original")) {
+		t.Fatalf("manifest = %+v, want one entry for album/photo.jpg", entries)
+	}
+
+	performRestore(backupDir, sourceDir, true)
+	if data, _ := os.ReadFile(photo); string(data) != "edited" {
+		t.Fatal("performRestore() dry run changed the file")
+	}
+
+	performRestore(backupDir, sourceDir, false)
+	data, err := os.ReadFile(photo)
+	if err != nil || string(data) != "original" {
+		t.Errorf("restored photo = %q, %v; want original", data, err)
+	}
+	if info, err := os.Stat(photo); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("restored photo mode = %v, want 0640", info.Mode().Perm())
+	}
+}
+
+func TestRestoreObject_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	object := filepath.Join(dir, "object")
+	if err := os.WriteFile(object, []byte("tampered"), 0644); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+
+	target := filepath.Join(dir, "photo.jpg")
+	if err := restoreObject(object, "0000", target); err == nil {
+		t.Error("restoreObject() with a corrupt copy: error = nil")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("restoreObject() wrote a corrupt copy")
+	}
+}
+
+func TestBackupStore_Nil(t *testing.T) {
+	var store *backupStore
+	if err := store.save("photo.jpg"); err != nil {
+		t.Errorf("nil store save() error = %v", err)
+	}
+}
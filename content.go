@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// matchContent marks a media file paired with its sidecar by content
+// rather than by name
+const matchContent = "content"
+
+// Decisions recorded in the content match review
+const (
+	contentApplied        = "applied"
+	contentBelowThreshold = "below-threshold"
+	contentAmbiguous      = "ambiguous"
+	contentNoCandidates   = "no-candidates"
+)
+
+// Points each signal adds to a candidate's score, which is capped at 100.
+// Takeout sidecars carry no dimensions, camera model or file size, so the
+// signals are the ones a sidecar and a renamed file can still share.
+const (
+	scoreExifTime      = 50 // a date tag holds the sidecar's moment
+	scoreExifTimeLocal = 40 // ... as local time in some timezone
+	scoreNameTime      = 25 // the file name spells the sidecar's moment
+	scoreNameTimeLocal = 20 // ... as local time in some timezone
+	scoreGPS           = 30 // the file's coordinates are the sidecar's
+	scoreExtension     = 10 // same extension as the JSON title
+	scoreDevice        = 10 // the camera maker fits the uploading device
+	maxScore           = 100
+)
+
+// contentTags are read from every candidate
+var contentTags = append(append([]string{}, timestampTags...), "GPSLatitude", "GPSLongitude", "Make")
+
+// nameTime finds a date and time such as 20190601_123456 or
+// 2019-06-01 12.34.56 in a file name
+var nameTime = regexp.MustCompile(`(\d{4})[-_.]?(\d{2})[-_.]?(\d{2})[-_. T]?(\d{2})[-_.:]?(\d{2})[-_.:]?(\d{2})`)
+
+// contentCandidate is a media file scored against a sidecar
+type contentCandidate struct {
+	MediaFile string   `json:"media_file"`
+	Score     int      `json:"score"`
+	Signals   []string `json:"signals,omitempty"`
+}
+
+// contentDecision records why a sidecar was or was not paired by content
+type contentDecision struct {
+	JSONFile   string             `json:"json_file"`
+	MediaFile  string             `json:"media_file,omitempty"`
+	Decision   string             `json:"decision"`
+	Score      int                `json:"score,omitempty"`
+	Candidates []contentCandidate `json:"candidates,omitempty"`
+}
+
+// contentMatcher pairs sidecars with media files in the same directory
+// whose names no longer match, e.g. after a third-party tool renamed them.
+// Only files no sidecar claims by name are candidates, and each is given
+// to one sidecar at most.
+type contentMatcher struct {
+	threshold int
+
+	mu        sync.Mutex
+	pools     map[string]*candidatePool
+	taken     map[string]bool
+	decisions []contentDecision
+}
+
+// candidatePool holds the unclaimed media files of a directory and their
+// tags, read once
+type candidatePool struct {
+	once  sync.Once
+	files []string
+	tags  map[string]map[string]any
+}
+
+func newContentMatcher(threshold int) *contentMatcher {
+	return &contentMatcher{
+		threshold: threshold,
+		pools:     make(map[string]*candidatePool),
+		taken:     make(map[string]bool),
+	}
+}
+
+// matchContent scores the unclaimed media files next to jsonPath against
+// its sidecar and returns the best one if it clears the threshold
+// unambiguously. It returns "" when content matching is off.
+func (m *mediaMatcher) matchContent(backend MetadataBackend, jsonPath string, meta photoMetadata) (string, string) {
+	cm := m.content
+	if cm == nil {
+		return "", ""
+	}
+
+	dir := filepath.Dir(jsonPath)
+	cm.mu.Lock()
+	pool, ok := cm.pools[dir]
+	if !ok {
+		pool = &candidatePool{}
+		cm.pools[dir] = pool
+	}
+	cm.mu.Unlock()
+	pool.once.Do(func() {
+		pool.files = m.unclaimedMedia(dir)
+		pool.tags = make(map[string]map[string]any, len(pool.files))
+		if len(pool.files) == 0 || backend == nil {
+			return
+		}
+		values, errs := readTagsBatch(backend, pool.files, contentTags...)
+		for i, path := range pool.files {
+			if errors.Is(errs[i], errUnsupportedTag) {
+				// The native backend alone still has the dates
+				values[i], errs[i] = backend.ReadTags(path, timestampTags...)
+			}
+			if errs[i] == nil {
+				pool.tags[path] = values[i]
+			}
+		}
+	})
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	decision := contentDecision{JSONFile: jsonPath, Decision: contentNoCandidates}
+	for _, path := range pool.files {
+		if cm.taken[path] {
+			continue
+		}
+		score, signals := scoreCandidate(meta, path, pool.tags[path])
+		if score > 0 {
+			decision.Candidates = append(decision.Candidates, contentCandidate{MediaFile: path, Score: score, Signals: signals})
+		}
+	}
+	sort.SliceStable(decision.Candidates, func(i, j int) bool {
+		return decision.Candidates[i].Score > decision.Candidates[j].Score
+	})
+
+	if len(decision.Candidates) > 0 {
+		best := decision.Candidates[0]
+		decision.MediaFile, decision.Score = best.MediaFile, best.Score
+		switch {
+		case best.Score < cm.threshold:
+			decision.Decision = contentBelowThreshold
+		case len(decision.Candidates) > 1 && decision.Candidates[1].Score == best.Score:
+			decision.Decision = contentAmbiguous
+		default:
+			decision.Decision = contentApplied
+			cm.taken[best.MediaFile] = true
+		}
+		if len(decision.Candidates) > 3 {
+			decision.Candidates = decision.Candidates[:3]
+		}
+	}
+	cm.decisions = append(cm.decisions, decision)
+
+	if decision.Decision != contentApplied {
+		return "", ""
+	}
+	return decision.MediaFile, matchContent
+}
+
+// unclaimedMedia lists the media files of dir that no sidecar there
+// matches by name, directly or as an edited copy or Live Photo video
+func (m *mediaMatcher) unclaimedMedia(dir string) []string {
+	names := m.files.listing(dir).files()
+
+	claimed := make(map[string]bool)
+	for _, name := range names {
+		if filepath.Ext(name) != ".json" || name == "metadata.json" {
+			continue
+		}
+		jsonPath := filepath.Join(dir, name)
+		meta, _ := readPhotoMetadata(jsonPath)
+		mediaPath, _ := m.find(jsonPath, meta)
+		if mediaPath == "" {
+			continue
+		}
+		claimed[mediaPath] = true
+		for _, related := range m.relatedFiles(mediaPath) {
+			claimed[related] = true
+		}
+	}
+
+	var unclaimed []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if isMediaFile(name) && !claimed[path] {
+			unclaimed = append(unclaimed, path)
+		}
+	}
+	return unclaimed
+}
+
+// scoreCandidate rates how well a media file fits a sidecar. A file of the
+// other kind, a video for a photo or the reverse, scores 0.
+func scoreCandidate(meta photoMetadata, mediaPath string, tags map[string]any) (int, []string) {
+	titleExt := strings.ToLower(filepath.Ext(meta.Title))
+	mediaExt := strings.ToLower(filepath.Ext(mediaPath))
+	if titleExt != "" && isVideoExtension(titleExt) != isVideoExtension(mediaExt) {
+		return 0, nil
+	}
+
+	var score int
+	var signals []string
+	add := func(points int, signal string) {
+		score += points
+		signals = append(signals, signal)
+	}
+
+	if seconds, err := strconv.ParseInt(meta.takenTimestamp(), 10, 64); err == nil {
+		taken := time.Unix(seconds, 0)
+
+		for _, tag := range timestampTags {
+			value, ok := tags[tag].(string)
+			if !ok {
+				continue
+			}
+			date, err := parseExifDate(value)
+			if err != nil {
+				continue
+			}
+			if moment := sameMoment(date, taken); moment == momentExact {
+				add(scoreExifTime, "exif-time")
+				break
+			} else if moment == momentLocal {
+				add(scoreExifTimeLocal, "exif-time-local")
+				break
+			}
+		}
+
+		if m := nameTime.FindStringSubmatch(filepath.Base(mediaPath)); m != nil {
+			if t, err := time.Parse("20060102150405", strings.Join(m[1:], "")); err == nil {
+				switch sameMoment(exifDate{Time: t}, taken) {
+				case momentExact:
+					add(scoreNameTime, "name-time")
+				case momentLocal:
+					add(scoreNameTimeLocal, "name-time-local")
+				}
+			}
+		}
+	}
+
+	gps := meta.location()
+	if gps.Latitude != 0 || gps.Longitude != 0 {
+		lat, latOK := tags["GPSLatitude"].(float64)
+		lon, lonOK := tags["GPSLongitude"].(float64)
+		// Coordinates are compared without their sign since the file may
+		// store the hemisphere in a separate reference tag
+		if latOK && lonOK && math.Abs(math.Abs(lat)-math.Abs(gps.Latitude)) < 0.001 && math.Abs(math.Abs(lon)-math.Abs(gps.Longitude)) < 0.001 {
+			add(scoreGPS, "gps")
+		}
+	}
+
+	if titleExt != "" && titleExt == mediaExt {
+		add(scoreExtension, "extension")
+	}
+
+	if maker, ok := tags["Make"].(string); ok && maker != "" {
+		isApple := strings.EqualFold(strings.TrimSpace(maker), "Apple")
+		switch meta.GooglePhotosOrigin.MobileUpload.DeviceType {
+		case "IOS_PHONE", "IOS_TABLET":
+			if isApple {
+				add(scoreDevice, "device")
+			}
+		case "ANDROID_PHONE", "ANDROID_TABLET":
+			if !isApple {
+				add(scoreDevice, "device")
+			}
+		}
+	}
+
+	return min(score, maxScore), signals
+}
+
+// How a date relates to the sidecar's moment
+const (
+	momentNone = iota
+	momentExact
+	momentLocal
+)
+
+// sameMoment compares a date with the moment a photo was taken. A date
+// without an offset is either UTC or local time in a timezone, which is a
+// whole number of quarter hours and at most 14 hours away from UTC.
+func sameMoment(date exifDate, taken time.Time) int {
+	diff := date.Sub(taken)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff <= time.Second {
+		return momentExact
+	}
+	if date.hasZone || diff > 14*time.Hour {
+		return momentNone
+	}
+	if off := (diff + time.Second) % (15 * time.Minute); off <= 2*time.Second {
+		return momentLocal
+	}
+	return momentNone
+}
+
+// isVideoExtension reports whether ext, in lower case, is a video format
+func isVideoExtension(ext string) bool {
+	switch ext {
+	case ".mp4", ".mov", ".avi", ".mkv", ".webm", ".m4v":
+		return true
+	}
+	return false
+}
+
+// writeReview saves every content match decision as an indented JSON
+// document
+func (cm *contentMatcher) writeReview(path string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	data, err := json.MarshalIndent(struct {
+		Threshold int               `json:"threshold"`
+		Decisions []contentDecision `json:"decisions"`
+	}{cm.threshold, cm.decisions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// counts returns how many sidecars got each decision
+func (cm *contentMatcher) counts() map[string]int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, d := range cm.decisions {
+		counts[d.Decision]++
+	}
+	return counts
+}
+
+// summary describes the decisions in one line
+func (cm *contentMatcher) summary() string {
+	counts := cm.counts()
+	return fmt.Sprintf("Content matching: %d applied, %d below threshold, %d ambiguous, %d without candidates",
+		counts[contentApplied], counts[contentBelowThreshold], counts[contentAmbiguous], counts[contentNoCandidates])
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameMoment(t *testing.T) {
+	taken := time.Date(2019, 6, 1, 10, 34, 56, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"2019:06:01 10:34:56", momentExact},
+		{"2019:06:01 12:34:56+02:00", momentExact},
+		{"2019:06:01 12:34:56", momentLocal},
+		{"2019:06:01 16:04:56", momentLocal}, // India, +05:30
+		{"2019:06:01 12:34:56+03:00", momentNone},
+		{"2019:06:01 12:35:40", momentNone},
+		{"2019:06:03 10:34:56", momentNone},
+	}
+
+	for _, tt := range tests {
+		date, err := parseExifDate(tt.value)
+		if err != nil {
+			t.Fatalf("parseExifDate(%q) error = %v", tt.value, err)
+		}
+		if got := sameMoment(date, taken); got != tt.want {
+			t.Errorf("sameMoment(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestScoreCandidate(t *testing.T) {
+	var meta photoMetadata
+	meta.Title = "IMG_1234.jpg"
+	meta.PhotoTakenTime.Timestamp = "1559385296" // 2019-06-01 10:34:56 UTC
+	meta.GeoDataExif = geoData{Latitude: 48.858370, Longitude: 2.294481}
+	meta.GooglePhotosOrigin.MobileUpload.DeviceType = "IOS_PHONE"
+
+	tests := []struct {
+		name      string
+		mediaPath string
+		tags      map[string]any
+		want      int
+		signals   string
+	}{
+		{
+			name:      "everything agrees",
+			mediaPath: "renamed.jpg",
+			tags:      map[string]any{"DateTimeOriginal": "2019:06:01 12:34:56", "GPSLatitude": 48.85837, "GPSLongitude": 2.29448, "Make": "Apple"},
+			want:      90,
+			signals:   "exif-time-local,gps,extension,device",
+		},
+		{
+			name:      "date in the file name",
+			mediaPath: "2019-06-01 12.34.56.jpg",
+			tags:      map[string]any{},
+			want:      30,
+			signals:   "name-time-local,extension",
+		},
+		{
+			name:      "different moment and place",
+			mediaPath: "other.jpeg",
+			tags:      map[string]any{"DateTimeOriginal": "2018:01:01 08:00:00", "GPSLatitude": 40.0, "GPSLongitude": -73.0, "Make": "Google"},
+			want:      0,
+			signals:   "",
+		},
+		{
+			name:      "video for a photo",
+			mediaPath: "renamed.mp4",
+			tags:      map[string]any{"CreateDate": "2019:06:01 10:34:56"},
+			want:      0,
+			signals:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, signals := scoreCandidate(meta, tt.mediaPath, tt.tags)
+			if got != tt.want || strings.Join(signals, ",") != tt.signals {
+				t.Errorf("scoreCandidate() = %d, %v, want %d, %s", got, signals, tt.want, tt.signals)
+			}
+		})
+	}
+}
+
+func TestMatchContent(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"holiday-001.jpg":   "renamed original",
+		"holiday-002.jpg":   "another photo",
+		"IMG_0001.jpg":      "named photo",
+		"IMG_0001.jpg.json": `{"title": "IMG_0001.jpg", "photoTakenTime": {"timestamp": "1559385296"}}`,
+		"IMG_1234.jpg.json": `{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1559385296"}}`,
+		"IMG_1235.jpg.json": `{"title": "IMG_1235.jpg", "photoTakenTime": {"timestamp": "1559385296"}}`,
+		"IMG_9999.jpg.json": `{"title": "IMG_9999.jpg", "photoTakenTime": {"timestamp": "1262304000"}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	backend := newFakeBackend()
+	backend.files[filepath.Join(dir, "holiday-001.jpg")] = map[string]string{"DateTimeOriginal": "2019:06:01 10:34:56"}
+	backend.files[filepath.Join(dir, "holiday-002.jpg")] = map[string]string{"DateTimeOriginal": "2019:07:14 18:00:00"}
+	backend.files[filepath.Join(dir, "IMG_0001.jpg")] = map[string]string{"DateTimeOriginal": "2019:06:01 10:34:56"}
+
+	matcher := newMediaMatcher(nil)
+	matcher.content = newContentMatcher(60)
+
+	jobs, err := prepareUpdate(1, filepath.Join(dir, "IMG_1234.jpg.json"), matcher, nil, nil, backend)
+	if err != nil {
+		t.Fatalf("prepareUpdate() error = %v", err)
+	}
+	if want := filepath.Join(dir, "holiday-001.jpg"); jobs[0].imagePath != want || jobs[0].match != matchContent {
+		t.Errorf("prepareUpdate() = %q (%s), want %q by content", jobs[0].imagePath, jobs[0].match, want)
+	}
+
+	// The file is taken, and a file a sidecar claims by name never is
+	if jobs, err := prepareUpdate(1, filepath.Join(dir, "IMG_1235.jpg.json"), matcher, nil, nil, backend); err == nil {
+		t.Errorf("prepareUpdate() = %q, want the taken file to be left alone", jobs[0].imagePath)
+	}
+	if jobs, err := prepareUpdate(1, filepath.Join(dir, "IMG_9999.jpg.json"), matcher, nil, nil, backend); err == nil {
+		t.Errorf("prepareUpdate() = %q, want no match below the threshold", jobs[0].imagePath)
+	}
+
+	reviewPath := filepath.Join(t.TempDir(), "review.json")
+	if err := matcher.content.writeReview(reviewPath); err != nil {
+		t.Fatalf("writeReview() error = %v", err)
+	}
+	data, err := os.ReadFile(reviewPath)
+	if err != nil {
+		t.Fatalf("Failed to read review: %v", err)
+	}
+	var review struct {
+		Threshold int               `json:"threshold"`
+		Decisions []contentDecision `json:"decisions"`
+	}
+	if err := json.Unmarshal(data, &review); err != nil {
+		t.Fatalf("Review is not valid JSON: %v", err)
+	}
+	var decisions []string
+	for _, d := range review.Decisions {
+		decisions = append(decisions, d.Decision)
+	}
+	if want := "applied,below-threshold,below-threshold"; strings.Join(decisions, ",") != want {
+		t.Errorf("review decisions = %v, want %s", decisions, want)
+	}
+}
+
+func TestMatchContent_AutoBackend(t *testing.T) {
+	installFakeExifTool(t)
+
+	dir := t.TempDir()
+	sidecar := `{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1559385296"},
+		"geoData": {"latitude": 40.7334367, "longitude": -73.5823593},
+		"googlePhotosOrigin": {"mobileUpload": {"deviceType": "IOS_PHONE"}}}`
+	files := map[string][]byte{
+		"renamed-gps.jpg":   buildTestJPEG(nil),
+		"IMG_1234.jpg.json": []byte(sidecar),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	backend, err := newAutoBackend(5*time.Second, 0)
+	if err != nil {
+		t.Fatalf("newAutoBackend() error = %v", err)
+	}
+	defer backend.Close()
+
+	// The native reader reads the dateless JPEG, but the coordinates and
+	// camera maker only come from exiftool
+	matcher := newMediaMatcher(nil)
+	matcher.content = newContentMatcher(40)
+	jobs, err := prepareUpdate(1, filepath.Join(dir, "IMG_1234.jpg.json"), matcher, nil, nil, backend)
+	if err != nil {
+		t.Fatalf("prepareUpdate() error = %v", err)
+	}
+	if want := filepath.Join(dir, "renamed-gps.jpg"); jobs[0].imagePath != want {
+		t.Errorf("prepareUpdate() = %q, want %q by content", jobs[0].imagePath, want)
+	}
+	if got := matcher.content.decisions[0].Candidates[0]; strings.Join(got.Signals, ",") != "gps,extension,device" {
+		t.Errorf("signals = %v, want gps, extension and device", got.Signals)
+	}
+}
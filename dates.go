@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var errInvalidDate = errors.New("invalid date")
+
+// exifDate is a date read from EXIF, XMP or QuickTime metadata
+type exifDate struct {
+	time.Time
+	hasZone bool // the value carried an explicit UTC offset
+}
+
+// Layouts with a zone come first so offsets are not silently dropped. When
+// parsing, Go accepts fractional seconds after the seconds field even if the
+// layout does not mention them, which covers subsecond values.
+var exifDateLayouts = []struct {
+	layout  string
+	hasZone bool
+}{
+	{"2006:01:02 15:04:05Z07:00", true},
+	{"2006:01:02 15:04:05-0700", true},
+	{"2006:01:02 15:04:05", false},
+	{"2006-01-02T15:04:05Z07:00", true},
+	{"2006-01-02T15:04Z07:00", true},
+	{"2006-01-02T15:04:05", false},
+	{"2006-01-02 15:04:05", false},
+	{"2006:01:02", false},
+	{"2006-01-02", false},
+}
+
+// parseExifDate parses the date formats found in EXIF, XMP and QuickTime
+// tags, e.g. "2017:06:08 19:42:41.123-04:00". Values without an offset are
+// returned in UTC with hasZone unset. Blank and all-zero dates are rejected.
+func parseExifDate(value string) (exifDate, error) {
+	value = strings.TrimSpace(value)
+	for _, l := range exifDateLayouts {
+		t, err := time.Parse(l.layout, value)
+		if err != nil {
+			continue
+		}
+		if t.IsZero() {
+			return exifDate{}, errInvalidDate
+		}
+		return exifDate{Time: t, hasZone: l.hasZone}, nil
+	}
+	return exifDate{}, errInvalidDate
+}
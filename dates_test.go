@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExifDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     time.Time
+		wantZone bool
+		wantErr  bool
+	}{
+		{value: "2017:06:08 19:42:41", want: time.Date(2017, 6, 8, 19, 42, 41, 0, time.UTC)},
+		{value: "2017:06:08 19:42:41.25", want: time.Date(2017, 6, 8, 19, 42, 41, 250000000, time.UTC)},
+		{value: "2017:06:08 19:42:41-04:00", want: time.Date(2017, 6, 8, 23, 42, 41, 0, time.UTC), wantZone: true},
+		{value: "2017:06:08 19:42:41.5+0200", want: time.Date(2017, 6, 8, 17, 42, 41, 500000000, time.UTC), wantZone: true},
+		{value: "2017:06:08 23:42:41Z", want: time.Date(2017, 6, 8, 23, 42, 41, 0, time.UTC), wantZone: true},
+		{value: "2019-04-01T10:00:00+09:00", want: time.Date(2019, 4, 1, 1, 0, 0, 0, time.UTC), wantZone: true},
+		{value: "2019-04-01T10:00+09:00", want: time.Date(2019, 4, 1, 1, 0, 0, 0, time.UTC), wantZone: true},
+		{value: "2017:06:08", want: time.Date(2017, 6, 8, 0, 0, 0, 0, time.UTC)},
+		{value: "0000:00:00 00:00:00", wantErr: true},
+		{value: "    :  :     :  :  ", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "Warning: bad format", wantErr: true},
+		{value: "2017:13:45 19:42:41", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseExifDate(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExifDate(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseExifDate(%q) = %v, want %v", tt.value, got.Time, tt.want)
+			}
+			if got.hasZone != tt.wantZone {
+				t.Errorf("parseExifDate(%q) hasZone = %v, want %v", tt.value, got.hasZone, tt.wantZone)
+			}
+		})
+	}
+}
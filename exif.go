@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TIFF field types used by EXIF
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+	tiffSLong     = 9
+	tiffSRational = 10
+)
+
+var tiffTypeSize = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// Tags that point at other IFDs or at the thumbnail. They are resolved while
+// parsing and are not kept as regular entries.
+const (
+	tagExifIFD         = 0x8769
+	tagGPSIFD          = 0x8825
+	tagInteropIFD      = 0xA005
+	tagThumbnailOffset = 0x0201
+	tagThumbnailLength = 0x0202
+)
+
+// Date tags read by the native reader
+const (
+	tagDateTime          = 0x0132
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagGPSDateStamp      = 0x001D
+)
+
+var errInvalidExif = errors.New("invalid EXIF data")
+
+// byteOrder is implemented by binary.LittleEndian and binary.BigEndian
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // raw value bytes in the byte order of the owning exifData
+}
+
+// exifData is a parsed TIFF structure as found in an EXIF block
+type exifData struct {
+	order     byteOrder
+	ifd0      []tiffEntry
+	exif      []tiffEntry
+	gps       []tiffEntry
+	interop   []tiffEntry
+	ifd1      []tiffEntry
+	thumbnail []byte
+}
+
+// parseExif parses a TIFF header and the IFDs reachable from it
+func parseExif(data []byte) (*exifData, error) {
+	if len(data) < 8 {
+		return nil, errInvalidExif
+	}
+
+	ed := &exifData{}
+	switch string(data[:2]) {
+	case "II":
+		ed.order = binary.LittleEndian
+	case "MM":
+		ed.order = binary.BigEndian
+	default:
+		return nil, errInvalidExif
+	}
+	if ed.order.Uint16(data[2:4]) != 42 {
+		return nil, errInvalidExif
+	}
+
+	ifd0, next, err := readIFD(data, ed.order, ed.order.Uint32(data[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	var pointers []tiffEntry
+	ed.ifd0, pointers = splitPointers(ifd0)
+	for _, p := range pointers {
+		switch p.tag {
+		case tagExifIFD:
+			entries, _, err := readIFD(data, ed.order, ed.order.Uint32(p.value))
+			if err != nil {
+				return nil, fmt.Errorf("reading EXIF IFD: %w", err)
+			}
+			var exifPointers []tiffEntry
+			ed.exif, exifPointers = splitPointers(entries)
+			for _, ep := range exifPointers {
+				if ep.tag == tagInteropIFD {
+					if entries, _, err := readIFD(data, ed.order, ed.order.Uint32(ep.value)); err == nil {
+						ed.interop = entries
+					}
+				}
+			}
+		case tagGPSIFD:
+			entries, _, err := readIFD(data, ed.order, ed.order.Uint32(p.value))
+			if err != nil {
+				return nil, fmt.Errorf("reading GPS IFD: %w", err)
+			}
+			ed.gps = entries
+		}
+	}
+
+	// IFD1 holds the thumbnail; a broken IFD1 is not worth failing over
+	if next != 0 {
+		if ifd1, _, err := readIFD(data, ed.order, next); err == nil {
+			ed.ifd1, ed.thumbnail = extractThumbnail(data, ed.order, ifd1)
+		}
+	}
+
+	return ed, nil
+}
+
+func readIFD(data []byte, order byteOrder, offset uint32) ([]tiffEntry, uint32, error) {
+	if offset < 8 || uint64(offset)+2 > uint64(len(data)) {
+		return nil, 0, errInvalidExif
+	}
+
+	count := uint32(order.Uint16(data[offset:]))
+	end := uint64(offset) + 2 + uint64(count)*12
+	if end > uint64(len(data)) {
+		return nil, 0, errInvalidExif
+	}
+
+	entries := make([]tiffEntry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		pos := offset + 2 + i*12
+		entry := tiffEntry{
+			tag:   order.Uint16(data[pos:]),
+			typ:   order.Uint16(data[pos+2:]),
+			count: order.Uint32(data[pos+4:]),
+		}
+
+		size, ok := tiffTypeSize[entry.typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(entry.count)
+		if total <= 4 {
+			entry.value = append([]byte(nil), data[pos+8:pos+8+uint32(total)]...)
+		} else {
+			valueOffset := uint64(order.Uint32(data[pos+8:]))
+			if valueOffset+total > uint64(len(data)) {
+				continue
+			}
+			entry.value = append([]byte(nil), data[valueOffset:valueOffset+total]...)
+		}
+		entries = append(entries, entry)
+	}
+
+	var next uint32
+	if end+4 <= uint64(len(data)) {
+		next = order.Uint32(data[end:])
+	}
+
+	return entries, next, nil
+}
+
+func splitPointers(entries []tiffEntry) (regular, pointers []tiffEntry) {
+	for _, e := range entries {
+		switch e.tag {
+		case tagExifIFD, tagGPSIFD, tagInteropIFD:
+			if len(e.value) == 4 {
+				pointers = append(pointers, e)
+			}
+		default:
+			regular = append(regular, e)
+		}
+	}
+	return regular, pointers
+}
+
+func extractThumbnail(data []byte, order byteOrder, ifd1 []tiffEntry) ([]tiffEntry, []byte) {
+	var offset, length uint32
+	var regular []tiffEntry
+	for _, e := range ifd1 {
+		switch e.tag {
+		case tagThumbnailOffset:
+			offset = e.uint32(order)
+		case tagThumbnailLength:
+			length = e.uint32(order)
+		default:
+			regular = append(regular, e)
+		}
+	}
+
+	if length == 0 || uint64(offset)+uint64(length) > uint64(len(data)) {
+		return regular, nil
+	}
+	return regular, append([]byte(nil), data[offset:offset+length]...)
+}
+
+func (e tiffEntry) uint32(order byteOrder) uint32 {
+	switch {
+	case e.typ == tiffShort && len(e.value) >= 2:
+		return uint32(order.Uint16(e.value))
+	case (e.typ == tiffLong || e.typ == tiffSLong) && len(e.value) >= 4:
+		return order.Uint32(e.value)
+	case e.typ == tiffByte && len(e.value) >= 1:
+		return uint32(e.value[0])
+	}
+	return 0
+}
+
+func (e tiffEntry) ascii() string {
+	return strings.TrimRight(string(e.value), "\x00 ")
+}
+
+func findEntry(entries []tiffEntry, tag uint16) (tiffEntry, bool) {
+	for _, e := range entries {
+		if e.tag == tag {
+			return e, true
+		}
+	}
+	return tiffEntry{}, false
+}
+
+// dates returns the date tags of the EXIF block using exiftool tag names
+func (ed *exifData) dates() map[string]string {
+	dates := make(map[string]string)
+	lookups := []struct {
+		entries []tiffEntry
+		tag     uint16
+		name    string
+	}{
+		{ed.ifd0, tagDateTime, "DateTime"},
+		{ed.exif, tagDateTimeOriginal, "DateTimeOriginal"},
+		{ed.exif, tagDateTimeDigitized, "CreateDate"},
+		{ed.gps, tagGPSDateStamp, "GPSDateStamp"},
+	}
+
+	for _, l := range lookups {
+		if e, ok := findEntry(l.entries, l.tag); ok && e.typ == tiffASCII {
+			if value := e.ascii(); value != "" {
+				dates[l.name] = value
+			}
+		}
+	}
+	return dates
+}
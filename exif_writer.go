@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errUnsupportedTag is returned when the native backend cannot read or write a tag
+var errUnsupportedTag = errors.New("tag not supported by native backend")
+
+// maxExifSegment is the largest EXIF payload that fits in a JPEG APP1 segment
+const maxExifSegment = 0xFFFF - 2 - 6
+
+// Writable tag locations
+const (
+	ifd0Dir = iota
+	exifDir
+	gpsDir
+)
+
+type exifTagSpec struct {
+	dir    int
+	tag    uint16
+	encode func(order byteOrder, value string) (typ uint16, count uint32, data []byte, err error)
+}
+
+// writableTags maps exiftool tag names to their EXIF location and encoding
+var writableTags = map[string]exifTagSpec{
+	"DateTimeOriginal":    {exifDir, tagDateTimeOriginal, encodeASCII},
+	"CreateDate":          {exifDir, tagDateTimeDigitized, encodeASCII},
+	"OffsetTime":          {exifDir, 0x9010, encodeASCII},
+	"OffsetTimeOriginal":  {exifDir, 0x9011, encodeASCII},
+	"OffsetTimeDigitized": {exifDir, 0x9012, encodeASCII},
+	"GPSLatitudeRef":      {gpsDir, 0x0001, encodeASCII},
+	"GPSLatitude":         {gpsDir, 0x0002, encodeGPSCoordinate},
+	"GPSLongitudeRef":     {gpsDir, 0x0003, encodeASCII},
+	"GPSLongitude":        {gpsDir, 0x0004, encodeGPSCoordinate},
+	"GPSAltitudeRef":      {gpsDir, 0x0005, encodeByte},
+	"GPSAltitude":         {gpsDir, 0x0006, encodeUnsignedRational},
+	"GPSTimeStamp":        {gpsDir, 0x0007, encodeGPSTime},
+	"GPSMapDatum":         {gpsDir, 0x0012, encodeASCII},
+	"GPSDateStamp":        {gpsDir, tagGPSDateStamp, encodeASCII},
+}
+
+func encodeASCII(_ byteOrder, value string) (uint16, uint32, []byte, error) {
+	data := append([]byte(value), 0)
+	return tiffASCII, uint32(len(data)), data, nil
+}
+
+func encodeByte(_ byteOrder, value string) (uint16, uint32, []byte, error) {
+	v, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return tiffByte, 1, []byte{byte(v)}, nil
+}
+
+func encodeUnsignedRational(order byteOrder, value string) (uint16, uint32, []byte, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	data := appendRational(nil, order, math.Abs(v), 1000)
+	return tiffRational, 1, data, nil
+}
+
+// encodeGPSCoordinate stores decimal degrees as degrees, minutes and seconds
+func encodeGPSCoordinate(order byteOrder, value string) (uint16, uint32, []byte, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	// Rounding to ten-thousandths of a second first carries 59.99996
+	// seconds into the minutes instead of writing 60 seconds
+	const unitsPerSecond = 10000
+	total := int64(math.Round(math.Abs(v) * 3600 * unitsPerSecond))
+	degrees := total / (3600 * unitsPerSecond)
+	minutes := total / (60 * unitsPerSecond) % 60
+	seconds := total % (60 * unitsPerSecond)
+
+	data := appendRational(nil, order, float64(degrees), 1)
+	data = appendRational(data, order, float64(minutes), 1)
+	data = appendRational(data, order, float64(seconds)/unitsPerSecond, unitsPerSecond)
+	return tiffRational, 3, data, nil
+}
+
+// encodeGPSTime stores an HH:MM:SS time as hours, minutes and seconds
+func encodeGPSTime(order byteOrder, value string) (uint16, uint32, []byte, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, 0, nil, fmt.Errorf("want HH:MM:SS")
+	}
+	var data []byte
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		data = appendRational(data, order, v, 1)
+	}
+	return tiffRational, 3, data, nil
+}
+
+func appendRational(b []byte, order byteOrder, v float64, denominator uint32) []byte {
+	b = order.AppendUint32(b, uint32(math.Round(v*float64(denominator))))
+	return order.AppendUint32(b, denominator)
+}
+
+// rawEntry is an IFD entry whose value field is kept exactly as stored, so
+// that entries we do not touch keep pointing at their original data
+type rawEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	field [4]byte
+}
+
+type rawIFD struct {
+	entries []rawEntry
+	next    uint32
+}
+
+// exifPatcher updates a TIFF block by appending changed IFDs and values
+// rather than re-laying out the block, which keeps offsets inside opaque
+// data such as maker notes valid
+type exifPatcher struct {
+	order byteOrder
+	data  []byte
+}
+
+func newExifPatcher(tiff []byte) (*exifPatcher, error) {
+	if len(tiff) == 0 {
+		// Fresh little-endian block with an empty IFD0
+		data := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		return &exifPatcher{order: binary.LittleEndian, data: data}, nil
+	}
+
+	if len(tiff) < 8 {
+		return nil, errInvalidExif
+	}
+	p := &exifPatcher{data: append([]byte(nil), tiff...)}
+	switch string(tiff[:2]) {
+	case "II":
+		p.order = binary.LittleEndian
+	case "MM":
+		p.order = binary.BigEndian
+	default:
+		return nil, errInvalidExif
+	}
+	return p, nil
+}
+
+func (p *exifPatcher) readIFD(offset uint32) (rawIFD, error) {
+	if offset < 8 || uint64(offset)+2 > uint64(len(p.data)) {
+		return rawIFD{}, errInvalidExif
+	}
+
+	count := uint32(p.order.Uint16(p.data[offset:]))
+	end := uint64(offset) + 2 + uint64(count)*12
+	if end > uint64(len(p.data)) {
+		return rawIFD{}, errInvalidExif
+	}
+
+	ifd := rawIFD{entries: make([]rawEntry, count)}
+	for i := range ifd.entries {
+		pos := offset + 2 + uint32(i)*12
+		e := &ifd.entries[i]
+		e.tag = p.order.Uint16(p.data[pos:])
+		e.typ = p.order.Uint16(p.data[pos+2:])
+		e.count = p.order.Uint32(p.data[pos+4:])
+		copy(e.field[:], p.data[pos+8:pos+12])
+	}
+	if end+4 <= uint64(len(p.data)) {
+		ifd.next = p.order.Uint32(p.data[end:])
+	}
+	return ifd, nil
+}
+
+// align pads the block to an even length as TIFF offsets must be word aligned
+func (p *exifPatcher) align() {
+	if len(p.data)%2 != 0 {
+		p.data = append(p.data, 0)
+	}
+}
+
+func (p *exifPatcher) appendIFD(ifd rawIFD) uint32 {
+	slices.SortFunc(ifd.entries, func(a, b rawEntry) int { return int(a.tag) - int(b.tag) })
+
+	p.align()
+	offset := uint32(len(p.data))
+	p.data = p.order.AppendUint16(p.data, uint16(len(ifd.entries)))
+	for _, e := range ifd.entries {
+		p.data = p.order.AppendUint16(p.data, e.tag)
+		p.data = p.order.AppendUint16(p.data, e.typ)
+		p.data = p.order.AppendUint32(p.data, e.count)
+		p.data = append(p.data, e.field[:]...)
+	}
+	p.data = p.order.AppendUint32(p.data, ifd.next)
+	return offset
+}
+
+// set stores a value in the IFD, appending out-of-line data to the block
+func (p *exifPatcher) set(ifd *rawIFD, tag, typ uint16, count uint32, value []byte) {
+	entry := rawEntry{tag: tag, typ: typ, count: count}
+	if len(value) <= 4 {
+		copy(entry.field[:], value)
+	} else {
+		p.align()
+		p.order.PutUint32(entry.field[:], uint32(len(p.data)))
+		p.data = append(p.data, value...)
+	}
+
+	for i := range ifd.entries {
+		if ifd.entries[i].tag == tag {
+			ifd.entries[i] = entry
+			return
+		}
+	}
+	ifd.entries = append(ifd.entries, entry)
+}
+
+func (p *exifPatcher) pointer(ifd rawIFD, tag uint16) (uint32, bool) {
+	for _, e := range ifd.entries {
+		if e.tag == tag && (e.typ == tiffLong || e.typ == 13) {
+			return p.order.Uint32(e.field[:]), true
+		}
+	}
+	return 0, false
+}
+
+// patchExifTags returns a copy of the TIFF block with the given tags set.
+// An empty block yields a new block holding only those tags.
+func patchExifTags(tiff []byte, tags map[string]string) ([]byte, error) {
+	p, err := newExifPatcher(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	tags = withGPSRefs(tags)
+
+	ifd0, err := p.readIFD(p.order.Uint32(p.data[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	subIFDs := map[int]*rawIFD{}
+	subPointers := map[int]uint16{exifDir: tagExifIFD, gpsDir: tagGPSIFD}
+	dirFor := func(dir int) (*rawIFD, error) {
+		if dir == ifd0Dir {
+			return &ifd0, nil
+		}
+		if ifd, ok := subIFDs[dir]; ok {
+			return ifd, nil
+		}
+		ifd := &rawIFD{}
+		if offset, ok := p.pointer(ifd0, subPointers[dir]); ok {
+			existing, err := p.readIFD(offset)
+			if err != nil {
+				return nil, err
+			}
+			*ifd = existing
+		}
+		subIFDs[dir] = ifd
+		return ifd, nil
+	}
+
+	// Apply tags in a stable order so output is deterministic
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		spec, ok := writableTags[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnsupportedTag, name)
+		}
+		typ, count, value, err := spec.encode(p.order, tags[name])
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s=%q: %v", name, tags[name], err)
+		}
+		ifd, err := dirFor(spec.dir)
+		if err != nil {
+			return nil, err
+		}
+		p.set(ifd, spec.tag, typ, count, value)
+	}
+
+	if gps, ok := subIFDs[gpsDir]; ok {
+		if _, ok := findRawEntry(*gps, 0x0000); !ok {
+			p.set(gps, 0x0000, tiffByte, 4, []byte{2, 3, 0, 0}) // GPSVersionID
+		}
+	}
+
+	// Write the changed sub-IFDs, then IFD0 pointing at them
+	for _, dir := range []int{exifDir, gpsDir} {
+		ifd, ok := subIFDs[dir]
+		if !ok {
+			continue
+		}
+		offset := p.appendIFD(*ifd)
+		var field [4]byte
+		p.order.PutUint32(field[:], offset)
+		p.set(&ifd0, subPointers[dir], tiffLong, 1, field[:])
+	}
+	// appendIFD may grow the block, so the header is patched afterwards
+	ifd0Offset := p.appendIFD(ifd0)
+	p.order.PutUint32(p.data[4:8], ifd0Offset)
+
+	return p.data, nil
+}
+
+func findRawEntry(ifd rawIFD, tag uint16) (rawEntry, bool) {
+	for _, e := range ifd.entries {
+		if e.tag == tag {
+			return e, true
+		}
+	}
+	return rawEntry{}, false
+}
+
+// withGPSRefs derives the hemisphere and altitude reference tags from signed
+// values when they are not given explicitly
+func withGPSRefs(tags map[string]string) map[string]string {
+	refs := []struct {
+		value, ref, positive, negative string
+	}{
+		{"GPSLatitude", "GPSLatitudeRef", "N", "S"},
+		{"GPSLongitude", "GPSLongitudeRef", "E", "W"},
+		{"GPSAltitude", "GPSAltitudeRef", "0", "1"},
+	}
+
+	out := make(map[string]string, len(tags)+len(refs))
+	for name, value := range tags {
+		out[name] = value
+	}
+	for _, r := range refs {
+		value, ok := tags[r.value]
+		if _, hasRef := tags[r.ref]; !ok || hasRef {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(value), "-") {
+			out[r.ref] = r.negative
+		} else {
+			out[r.ref] = r.positive
+		}
+	}
+	return out
+}
+
+// writeJPEGTags sets EXIF tags in a JPEG file by replacing or inserting its
+// APP1 EXIF segment. Image data is copied through untouched.
+func writeJPEGTags(filePath string, tags map[string]string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return errUnsupportedFormat
+	}
+
+	// Locate the existing EXIF segment and the insertion point after JFIF
+	insertAt, exifStart, exifEnd := 2, -1, -1
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			return fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE0 && pos == insertAt {
+			insertAt = end
+		}
+		if marker == 0xE1 && exifStart < 0 && bytes.HasPrefix(segment, exifHeader) {
+			exifStart, exifEnd = pos, end
+		}
+		pos = end
+	}
+
+	var tiff []byte
+	if exifStart >= 0 {
+		tiff = data[exifStart+4+len(exifHeader) : exifEnd]
+	} else {
+		exifStart, exifEnd = insertAt, insertAt
+	}
+
+	patched, err := patchExifTags(tiff, tags)
+	if err != nil {
+		return err
+	}
+	if len(patched) > maxExifSegment {
+		return fmt.Errorf("EXIF data too large for a JPEG segment (%d bytes)", len(patched))
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data) + len(patched) - len(tiff) + 16)
+	out.Write(data[:exifStart])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(2+len(exifHeader)+len(patched)))
+	out.Write(exifHeader)
+	out.Write(patched)
+	out.Write(data[exifEnd:])
+
+	return replaceFile(filePath, out.Bytes())
+}
+
+// replaceFile atomically replaces the contents of a file, keeping its mode,
+// or creates it
+func replaceFile(filePath string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
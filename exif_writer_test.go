@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func copyTestJPEG(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("test/20170608_194241.jpg")
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to copy test image: %v", err)
+	}
+	return path
+}
+
+// readJPEGExif returns the parsed EXIF block and the bytes from the start of scan
+func readJPEGExif(t *testing.T, path string) (*exifData, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	var ed *exifData
+	for pos := 2; pos+4 <= len(data); {
+		marker := data[pos+1]
+		if marker == 0xDA {
+			return ed, data[pos:]
+		}
+		end := pos + 2 + (int(data[pos+2])<<8 | int(data[pos+3]))
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) && ed == nil {
+			if ed, err = parseExif(segment[len(exifHeader):]); err != nil {
+				t.Fatalf("parseExif() error = %v", err)
+			}
+		}
+		pos = end
+	}
+	t.Fatalf("No start of scan found in %s", path)
+	return nil, nil
+}
+
+func rationals(ed *exifData, entry tiffEntry) []float64 {
+	var values []float64
+	for i := 0; i+8 <= len(entry.value); i += 8 {
+		num := ed.order.Uint32(entry.value[i:])
+		den := ed.order.Uint32(entry.value[i+4:])
+		values = append(values, float64(num)/float64(den))
+	}
+	return values
+}
+
+func TestPatchExifTags_NewBlock(t *testing.T) {
+	tiff, err := patchExifTags(nil, map[string]string{
+		"DateTimeOriginal":   "2017:06:08 19:42:41",
+		"OffsetTimeOriginal": "-04:00",
+		"GPSLatitude":        "40.733437",
+		"GPSLongitude":       "-73.582359",
+		"GPSAltitude":        "-11.199",
+	})
+	if err != nil {
+		t.Fatalf("patchExifTags() error = %v", err)
+	}
+
+	ed, err := parseExif(tiff)
+	if err != nil {
+		t.Fatalf("parseExif() error = %v", err)
+	}
+
+	if got := ed.dates()["DateTimeOriginal"]; got != "2017:06:08 19:42:41" {
+		t.Errorf("DateTimeOriginal = %q, want 2017:06:08 19:42:41", got)
+	}
+	if e, _ := findEntry(ed.exif, 0x9011); e.ascii() != "-04:00" {
+		t.Errorf("OffsetTimeOriginal = %q, want -04:00", e.ascii())
+	}
+
+	refs := map[uint16]string{0x0001: "N", 0x0003: "W"}
+	for tag, want := range refs {
+		if e, _ := findEntry(ed.gps, tag); e.ascii() != want {
+			t.Errorf("GPS tag 0x%04X = %q, want %q", tag, e.ascii(), want)
+		}
+	}
+	if e, _ := findEntry(ed.gps, 0x0005); len(e.value) != 1 || e.value[0] != 1 {
+		t.Errorf("GPSAltitudeRef = %v, want below sea level", e.value)
+	}
+
+	lat, _ := findEntry(ed.gps, 0x0002)
+	dms := rationals(ed, lat)
+	if len(dms) != 3 {
+		t.Fatalf("GPSLatitude has %d components, want 3", len(dms))
+	}
+	if decimal := dms[0] + dms[1]/60 + dms[2]/3600; math.Abs(decimal-40.733437) > 1e-6 {
+		t.Errorf("GPSLatitude = %v, want 40.733437", decimal)
+	}
+}
+
+func TestPatchExifTags_GPSBlock(t *testing.T) {
+	gps := geoData{Latitude: -33.8688, Longitude: 151.2093, Altitude: 58}
+	tiff, err := patchExifTags(nil, exifGPSTags(gps, time.Unix(1496965361, 0)))
+	if err != nil {
+		t.Fatalf("patchExifTags() error = %v", err)
+	}
+	ed, err := parseExif(tiff)
+	if err != nil {
+		t.Fatalf("parseExif() error = %v", err)
+	}
+
+	ascii := map[uint16]string{0x0001: "S", 0x0003: "E", 0x0012: "WGS-84", 0x001D: "2017:06:08"}
+	for tag, want := range ascii {
+		if e, _ := findEntry(ed.gps, tag); e.ascii() != want {
+			t.Errorf("GPS tag 0x%04X = %q, want %q", tag, e.ascii(), want)
+		}
+	}
+	if e, _ := findEntry(ed.gps, 0x0005); len(e.value) != 1 || e.value[0] != 0 {
+		t.Errorf("GPSAltitudeRef = %v, want above sea level", e.value)
+	}
+	if e, ok := findEntry(ed.gps, 0x0000); !ok || len(e.value) != 4 || e.value[0] != 2 {
+		t.Errorf("GPSVersionID = %v, want 2.3.0.0", e.value)
+	}
+
+	stamp, _ := findEntry(ed.gps, 0x0007)
+	if hms := rationals(ed, stamp); len(hms) != 3 || hms[0] != 23 || hms[1] != 42 || hms[2] != 41 {
+		t.Errorf("GPSTimeStamp = %v, want 23:42:41 UTC", hms)
+	}
+}
+
+func TestEncodeGPSCoordinate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  [3]float64
+	}{
+		{"40.733437", [3]float64{40, 44, 0.3732}},
+		{"-73.582359", [3]float64{73, 34, 56.4924}},
+		{"10.99999999", [3]float64{11, 0, 0}}, // 59.99996 seconds round up
+		{"0.01666666", [3]float64{0, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		_, count, data, err := encodeGPSCoordinate(binary.BigEndian, tt.value)
+		if err != nil || count != 3 {
+			t.Fatalf("encodeGPSCoordinate(%s) = %d values, error %v", tt.value, count, err)
+		}
+		dms := rationals(&exifData{order: binary.BigEndian}, tiffEntry{value: data})
+		if len(dms) != 3 || [3]float64(dms) != tt.want {
+			t.Errorf("encodeGPSCoordinate(%s) = %v, want %v", tt.value, dms, tt.want)
+		}
+	}
+}
+
+func TestPatchExifTags_UnsupportedTag(t *testing.T) {
+	_, err := patchExifTags(nil, map[string]string{"Keywords": "holiday"})
+	if !errors.Is(err, errUnsupportedTag) {
+		t.Errorf("patchExifTags() error = %v, want errUnsupportedTag", err)
+	}
+}
+
+func TestWriteJPEGTags_ExistingExif(t *testing.T) {
+	path := copyTestJPEG(t)
+	before, scanBefore := readJPEGExif(t, path)
+
+	err := writeJPEGTags(path, map[string]string{
+		"DateTimeOriginal": "2001:02:03 04:05:06",
+		"CreateDate":       "2001:02:03 04:05:06",
+	})
+	if err != nil {
+		t.Fatalf("writeJPEGTags() error = %v", err)
+	}
+
+	after, scanAfter := readJPEGExif(t, path)
+	if !bytes.Equal(scanBefore, scanAfter) {
+		t.Error("writeJPEGTags() modified image data")
+	}
+	if got := after.dates()["DateTimeOriginal"]; got != "2001:02:03 04:05:06" {
+		t.Errorf("DateTimeOriginal = %q, want 2001:02:03 04:05:06", got)
+	}
+	if !bytes.Equal(before.thumbnail, after.thumbnail) {
+		t.Error("writeJPEGTags() lost the thumbnail")
+	}
+
+	// Tags we did not touch are preserved
+	makeBefore, _ := findEntry(before.ifd0, 0x010F)
+	makeAfter, _ := findEntry(after.ifd0, 0x010F)
+	if makeBefore.ascii() == "" || makeBefore.ascii() != makeAfter.ascii() {
+		t.Errorf("Make = %q, want %q", makeAfter.ascii(), makeBefore.ascii())
+	}
+	if len(after.gps) < len(before.gps) {
+		t.Errorf("GPS IFD has %d entries, want at least %d", len(after.gps), len(before.gps))
+	}
+}
+
+func TestWriteJPEGTags_NoExif(t *testing.T) {
+	path := writeTestFile(t, "bare.jpg", buildTestJPEG(nil))
+
+	if err := writeJPEGTags(path, map[string]string{"DateTimeOriginal": "2010:01:01 12:00:00"}); err != nil {
+		t.Fatalf("writeJPEGTags() error = %v", err)
+	}
+
+	dates, err := nativeReader{}.ReadDates(path)
+	if err != nil {
+		t.Fatalf("ReadDates() error = %v", err)
+	}
+	if dates["DateTimeOriginal"] != "2010:01:01 12:00:00" {
+		t.Errorf("DateTimeOriginal = %q, want 2010:01:01 12:00:00", dates["DateTimeOriginal"])
+	}
+}
+
+func TestWriteJPEGTags_NotJPEG(t *testing.T) {
+	path := writeTestFile(t, "image.png", append([]byte(nil), pngSignature...))
+	if err := writeJPEGTags(path, map[string]string{"DateTimeOriginal": "2010:01:01 12:00:00"}); err != errUnsupportedFormat {
+		t.Errorf("writeJPEGTags() error = %v, want errUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExifTool represents a persistent exiftool process. A command that runs
+// past the timeout or finds the process dead kills it; the next command
+// starts a fresh one.
+type ExifTool struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	stderr   *bufio.Reader
+	lines    chan string   // stdout lines, closed once the process has exited
+	errLines chan string   // stderr lines, closed once the process has exited
+	stop     chan struct{} // closed when the process is killed
+	timeout  time.Duration // deadline per command, 0 disables it
+	retries  int           // extra attempts for a command after a hang or crash
+	messages map[string][]fileMessage
+}
+
+var (
+	errExifToolTimeout = errors.New("exiftool did not respond in time")
+	errExifToolExited  = errors.New("exiftool exited unexpectedly")
+)
+
+// NewExifTool starts a new persistent exiftool process
+func NewExifTool(timeout time.Duration, retries int) (*ExifTool, error) {
+	et := &ExifTool{
+		timeout:  timeout,
+		retries:  retries,
+		messages: make(map[string][]fileMessage),
+	}
+	if err := et.start(); err != nil {
+		return nil, err
+	}
+	return et, nil
+}
+
+func (et *ExifTool) start() error {
+	cmd := exec.Command("exiftool", "-stay_open", "True", "-@", "-")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		stdin.Close()
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		return err
+	}
+
+	et.cmd = cmd
+	et.stdin = stdin
+	et.stdout = bufio.NewReader(stdout)
+	et.stderr = bufio.NewReader(stderr)
+	et.lines = make(chan string)
+	et.errLines = make(chan string)
+	et.stop = make(chan struct{})
+
+	// Reading happens in the background so receive can give up on a hung
+	// process. Wait must only be called once both pipes have been drained.
+	var pumps sync.WaitGroup
+	pump := func(r *bufio.Reader, lines chan<- string, stop <-chan struct{}) {
+		defer pumps.Done()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			select {
+			case lines <- line:
+			case <-stop:
+			}
+		}
+	}
+	pumps.Add(2)
+	go pump(et.stdout, et.lines, et.stop)
+	go pump(et.stderr, et.errLines, et.stop)
+	go func(lines, errLines chan string) {
+		pumps.Wait()
+		cmd.Wait()
+		close(lines)
+		close(errLines)
+	}(et.lines, et.errLines)
+
+	return nil
+}
+
+// kill stops a hung or broken process; the next command restarts it
+func (et *ExifTool) kill() {
+	if et.cmd == nil {
+		return
+	}
+	close(et.stop)
+	et.stdin.Close()
+	et.cmd.Process.Kill()
+	for range et.lines {
+	}
+	et.cmd = nil
+}
+
+// Execute runs a command through the persistent exiftool process. When the
+// process hangs or dies it is restarted and the command retried.
+func (et *ExifTool) Execute(args ...string) (string, error) {
+	output, _, err := et.execute(args...)
+	return output, err
+}
+
+// execute is Execute returning what exiftool wrote to stderr as well
+func (et *ExifTool) execute(args ...string) (string, string, error) {
+	var err error
+	for attempt := 0; attempt <= et.retries; attempt++ {
+		var output, errOutput string
+		if output, errOutput, err = et.run(args...); err == nil {
+			return output, errOutput, nil
+		}
+	}
+	if et.retries > 0 {
+		return "", "", fmt.Errorf("giving up after %d attempts: %w", et.retries+1, err)
+	}
+	return "", "", err
+}
+
+// run makes a single attempt at a command, starting exiftool if needed
+func (et *ExifTool) run(args ...string) (string, string, error) {
+	if et.cmd == nil {
+		if err := et.start(); err != nil {
+			return "", "", fmt.Errorf("restarting exiftool: %w", err)
+		}
+	}
+	sent := et.send([]string{""}, [][]string{args})
+	defer func() { <-sent }()
+	return et.receive("")
+}
+
+// send writes commands, each terminated by -execute<seq>, from another
+// goroutine so their responses can be read while later commands are still
+// being written. exiftool stops reading its input once its output pipe is
+// full, so writing a whole batch before reading would stall both sides.
+// -echo4 marks the end of a command on stderr the way {ready} does on
+// stdout. The returned channel is closed once writing stops; a receive
+// that gives up kills the process, which ends the writing.
+func (et *ExifTool) send(seqs []string, commands [][]string) <-chan struct{} {
+	stdin := et.stdin
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i, args := range commands {
+			args = append(slices.Clone(args), "-echo4", "{ready"+seqs[i]+"}", "-execute"+seqs[i])
+			for _, arg := range args {
+				// A failed write means exiftool is gone, which receive reports
+				if _, err := fmt.Fprintln(stdin, arg); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return sent
+}
+
+// receive reads the stdout and stderr output of the command sent with the
+// same seq
+func (et *ExifTool) receive(seq string) (string, string, error) {
+	ready := "{ready" + seq + "}"
+
+	var deadline <-chan time.Time
+	if et.timeout > 0 {
+		timer := time.NewTimer(et.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	// Both streams are read together so a chatty stderr cannot fill its
+	// pipe and stall exiftool before it finishes the command
+	var output, errOutput strings.Builder
+	lines, errLines := et.lines, et.errLines
+	for lines != nil || errLines != nil {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				et.kill()
+				return "", "", errExifToolExited
+			}
+			if strings.TrimSpace(line) == ready {
+				lines = nil
+			} else {
+				output.WriteString(line)
+			}
+		case line, ok := <-errLines:
+			if !ok {
+				et.kill()
+				return "", "", errExifToolExited
+			}
+			if strings.TrimSpace(line) == ready {
+				errLines = nil
+			} else {
+				errOutput.WriteString(line)
+			}
+		case <-deadline:
+			et.kill()
+			return "", "", errExifToolTimeout
+		}
+	}
+	return strings.TrimSpace(output.String()), strings.TrimSpace(errOutput.String()), nil
+}
+
+// Close terminates the persistent exiftool process
+func (et *ExifTool) Close() error {
+	if et.cmd == nil {
+		return nil
+	}
+
+	if _, err := fmt.Fprintln(et.stdin, "-stay_open\nFalse"); err != nil {
+		et.kill()
+		return err
+	}
+	et.stdin.Close()
+
+	// Give exiftool the usual deadline to finish before killing it
+	var deadline <-chan time.Time
+	if et.timeout > 0 {
+		deadline = time.After(et.timeout)
+	}
+	for {
+		select {
+		case _, ok := <-et.lines:
+			if !ok {
+				et.cmd = nil
+				return nil
+			}
+		case <-et.errLines:
+		case <-deadline:
+			et.kill()
+			return errExifToolTimeout
+		}
+	}
+}
+
+// TakeMessages returns and forgets the warnings and errors exiftool printed
+// for a file
+func (et *ExifTool) TakeMessages(filePath string) []fileMessage {
+	messages := et.messages[filePath]
+	delete(et.messages, filePath)
+	return messages
+}
+
+func (et *ExifTool) addMessages(errOutput string, filePaths []string) {
+	for filePath, messages := range parseMessages(errOutput, filePaths) {
+		et.messages[filePath] = append(et.messages[filePath], messages...)
+	}
+}
+
+// ReadTags reads the given tags of a file as typed JSON values
+func (et *ExifTool) ReadTags(filePath string, tags ...string) (map[string]any, error) {
+	results, errs := et.ReadTagsBatch([]string{filePath}, tags...)
+	return results[0], errs[0]
+}
+
+// WriteTags writes the given tags to a file in place
+func (et *ExifTool) WriteTags(filePath string, tags map[string]string) error {
+	output, errOutput, err := et.execute(writeArgs(filePath, tags)...)
+	if err != nil {
+		return err
+	}
+	return et.writeResult(filePath, output, errOutput)
+}
+
+// writeResult records the messages of a write and turns a refused write
+// into an error carrying exiftool's reason
+func (et *ExifTool) writeResult(filePath, output, errOutput string) error {
+	messages := parseMessages(errOutput, []string{filePath})[filePath]
+	et.messages[filePath] = append(et.messages[filePath], messages...)
+	for _, m := range messages {
+		if m.Level == "error" {
+			return errors.New(m.Message)
+		}
+	}
+	return parseWriteSummary(output)
+}
+
+// ReadTagsBatch reads the given tags of several files with a single command.
+// Values are requested unformatted (-n) as JSON and matched to files through
+// the SourceFile key.
+func (et *ExifTool) ReadTagsBatch(filePaths []string, tags ...string) ([]map[string]any, []error) {
+	args := []string{"-json", "-n"}
+	for _, tag := range tags {
+		args = append(args, "-"+tag)
+	}
+	args = append(args, filePaths...)
+
+	if len(filePaths) == 1 {
+		output, errOutput, err := et.execute(args...)
+		if err != nil {
+			return make([]map[string]any, 1), []error{err}
+		}
+		et.addMessages(errOutput, filePaths)
+		return parseJSONBatch(output, filePaths)
+	}
+
+	output, errOutput, err := et.run(args...)
+	if err == nil {
+		et.addMessages(errOutput, filePaths)
+		return parseJSONBatch(output, filePaths)
+	}
+
+	// A file in the batch hung or crashed exiftool. Go through the files one
+	// by one so only that file ends up failing.
+	results := make([]map[string]any, len(filePaths))
+	errs := make([]error, len(filePaths))
+	for i, filePath := range filePaths {
+		results[i], errs[i] = et.ReadTags(filePath, tags...)
+	}
+	return results, errs
+}
+
+// WriteTagsBatch sends one numbered command per file and collects the
+// responses as they come, so each file gets its own result in a single
+// round-trip. If exiftool hangs or dies part way, the files without a
+// result are written one by one.
+func (et *ExifTool) WriteTagsBatch(filePaths []string, tags []map[string]string) []error {
+	errs := make([]error, len(filePaths))
+	if et.cmd == nil {
+		if err := et.start(); err != nil {
+			return repeatError(fmt.Errorf("restarting exiftool: %w", err), len(filePaths))
+		}
+	}
+
+	seqs := make([]string, len(filePaths))
+	commands := make([][]string, len(filePaths))
+	for i, filePath := range filePaths {
+		seqs[i], commands[i] = strconv.Itoa(i+1), writeArgs(filePath, tags[i])
+	}
+	sent := et.send(seqs, commands)
+
+	done := 0
+	for ; done < len(filePaths); done++ {
+		output, errOutput, err := et.receive(seqs[done])
+		if err != nil {
+			break
+		}
+		errs[done] = et.writeResult(filePaths[done], output, errOutput)
+	}
+	<-sent
+
+	for i := done; i < len(filePaths); i++ {
+		errs[i] = et.WriteTags(filePaths[i], tags[i])
+	}
+	return errs
+}
+
+func writeArgs(filePath string, tags map[string]string) []string {
+	args := []string{"-overwrite_original"}
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		if rawValueTags[name] {
+			args = append(args, fmt.Sprintf("-%s#=%s", name, tags[name]))
+			continue
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", name, tags[name]))
+	}
+	return append(args, filePath)
+}
+
+// parseWriteSummary checks exiftool's summary lines after a write
+func parseWriteSummary(output string) error {
+	if strings.Contains(output, "weren't updated due to errors") {
+		return errors.New("exiftool failed to update the file")
+	}
+	return nil
+}
+
+// parseMessages sorts exiftool's stderr lines by file. exiftool ends a
+// message with " - <file>" when a command covers several files; messages
+// that name no file are attached to every file of the command.
+func parseMessages(errOutput string, filePaths []string) map[string][]fileMessage {
+	messages := make(map[string][]fileMessage)
+	for _, line := range strings.Split(errOutput, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		m := fileMessage{Level: "warning", Message: line}
+		if text, ok := strings.CutPrefix(line, "Error:"); ok {
+			m = fileMessage{Level: "error", Message: strings.TrimSpace(text)}
+		} else if text, ok := strings.CutPrefix(line, "Warning:"); ok {
+			m.Message = strings.TrimSpace(text)
+		}
+
+		owners := filePaths
+		for _, filePath := range filePaths {
+			if text, ok := strings.CutSuffix(m.Message, " - "+filePath); ok {
+				m.Message = text
+				owners = []string{filePath}
+				break
+			}
+		}
+		for _, filePath := range owners {
+			messages[filePath] = append(messages[filePath], m)
+		}
+	}
+	return messages
+}
+
+// parseJSONBatch splits exiftool -json output into per-file tag values
+func parseJSONBatch(output string, filePaths []string) ([]map[string]any, []error) {
+	results := make([]map[string]any, len(filePaths))
+
+	var entries []map[string]any
+	if err := json.Unmarshal([]byte(output), &entries); err != nil {
+		return results, repeatError(fmt.Errorf("parsing exiftool output: %v", err), len(filePaths))
+	}
+
+	bySource := make(map[string]map[string]any, len(entries))
+	for _, entry := range entries {
+		if source, ok := entry["SourceFile"].(string); ok {
+			bySource[source] = entry
+		}
+	}
+
+	errs := make([]error, len(filePaths))
+	for i, filePath := range filePaths {
+		entry, ok := bySource[filePath]
+		if !ok {
+			errs[i] = errors.New("no metadata returned by exiftool")
+			continue
+		}
+		if msg, ok := entry["Error"].(string); ok {
+			errs[i] = errors.New(msg)
+			continue
+		}
+
+		delete(entry, "SourceFile")
+		results[i] = entry
+	}
+	return results, errs
+}
+
+func repeatError(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJSONBatch(t *testing.T) {
+	output := `[{
+  "SourceFile": "a.jpg",
+  "DateTimeOriginal": "2017:06:08 19:42:41"
+},
+{
+  "SourceFile": "broken.jpg",
+  "Error": "File format error"
+}]`
+
+	results, errs := parseJSONBatch(output, []string{"a.jpg", "broken.jpg", "missing.jpg"})
+
+	if errs[0] != nil || results[0]["DateTimeOriginal"] != "2017:06:08 19:42:41" {
+		t.Errorf("a.jpg = %v, %v; want DateTimeOriginal", results[0], errs[0])
+	}
+	if _, ok := results[0]["SourceFile"]; ok {
+		t.Error("SourceFile should not be reported as a tag")
+	}
+	if errs[1] == nil || errs[1].Error() != "File format error" {
+		t.Errorf("broken.jpg error = %v, want File format error", errs[1])
+	}
+	if errs[2] == nil {
+		t.Error("missing.jpg error = nil, want an error for a file without output")
+	}
+
+	_, errs = parseJSONBatch("not json", []string{"a.jpg"})
+	if errs[0] == nil {
+		t.Error("parseJSONBatch() with invalid output: error = nil")
+	}
+}
+
+func TestParseWriteSummary(t *testing.T) {
+	tests := []struct {
+		output  string
+		wantErr bool
+	}{
+		{"    1 image files updated", false},
+		{"    0 image files updated\n    1 image files unchanged", false},
+		{"    0 image files updated\n    1 files weren't updated due to errors", true},
+	}
+
+	for _, tt := range tests {
+		if err := parseWriteSummary(tt.output); (err != nil) != tt.wantErr {
+			t.Errorf("parseWriteSummary(%q) error = %v, wantErr %v", tt.output, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseMessages(t *testing.T) {
+	errOutput := "Warning: [minor] Bad MakerNotes directory - a.jpg\n" +
+		"Error: Not a valid JPG (looks more like a PNG) - b.jpg\n" +
+		"Warning: Something without a file"
+
+	messages := parseMessages(errOutput, []string{"a.jpg", "b.jpg"})
+
+	want := map[string][]fileMessage{
+		"a.jpg": {
+			{Level: "warning", Message: "[minor] Bad MakerNotes directory"},
+			{Level: "warning", Message: "Something without a file"},
+		},
+		"b.jpg": {
+			{Level: "error", Message: "Not a valid JPG (looks more like a PNG)"},
+			{Level: "warning", Message: "Something without a file"},
+		},
+	}
+	for filePath, wantMessages := range want {
+		if !slices.Equal(messages[filePath], wantMessages) {
+			t.Errorf("parseMessages()[%s] = %v, want %v", filePath, messages[filePath], wantMessages)
+		}
+	}
+}
+
+func TestExifTool_Batch(t *testing.T) {
+	et, err := NewExifTool(30*time.Second, 2)
+	if err != nil {
+		t.Skipf("Skipping test: exiftool not available: %v", err)
+	}
+	defer et.Close()
+
+	paths := []string{"test/20170608_194241.jpg", "test/does-not-exist.jpg"}
+	results, errs := et.ReadTagsBatch(paths, "DateTimeOriginal")
+	if errs[0] != nil || results[0]["DateTimeOriginal"] != "2017:06:08 19:42:41" {
+		t.Errorf("ReadTagsBatch() first file = %v, %v", results[0], errs[0])
+	}
+	if errs[1] == nil {
+		t.Error("ReadTagsBatch() missing file: error = nil")
+	}
+
+	path := copyTestJPEG(t)
+	writeErrs := et.WriteTagsBatch([]string{path, "test/does-not-exist.jpg"}, []map[string]string{
+		{"DateTimeOriginal": "2001:02:03 04:05:06"},
+		{"DateTimeOriginal": "2001:02:03 04:05:06"},
+	})
+	if writeErrs[0] != nil {
+		t.Errorf("WriteTagsBatch() first file error = %v", writeErrs[0])
+	}
+}
+
+// fakeExifTool stands in for exiftool in stay_open mode. Commands for files
+// named *hang* never finish, files named *crash* kill the process, files
+// named *png* are refused with an error on stderr and files named *gps*
+// have coordinates and a camera maker but no date. Every start is recorded
+// in the returned file.
+const fakeExifTool = `#!/bin/sh
+echo start >> "$(dirname "$0")/starts"
+while IFS= read -r line; do
+  if [ -n "$echo4" ]; then
+    sentinel="$line"
+    echo4=
+    continue
+  fi
+  case "$line" in
+    False) exit 0 ;;
+    -echo4) echo4=1 ;;
+    -execute*)
+      case "$file" in
+        *hang*) exec sleep 10 ;;
+        *crash*) exit 1 ;;
+        *png*)
+          echo "Error: Not a valid JPG (looks more like a PNG) - $file" >&2
+          echo "    0 image files updated"
+          echo "    1 files weren't updated due to errors" ;;
+        *gps*)
+          printf '[{"SourceFile": "%s", "GPSLatitude": 40.7334367, "GPSLongitude": -73.5823593, "Make": "Apple"}]\n' "$file" ;;
+        *)
+          echo "Warning: [minor] Fake warning - $file" >&2
+          printf '[{"SourceFile": "%s", "DateTimeOriginal": "2017:06:08 19:42:41"}]\n' "$file" ;;
+      esac
+      echo "$sentinel" >&2
+      echo "{ready${line#-execute}}" ;;
+    -*) ;;
+    *) file="$line" ;;
+  esac
+done
+`
+
+func installFakeExifTool(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test: fake exiftool needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "exiftool"), []byte(fakeExifTool), 0755); err != nil {
+		t.Fatalf("Failed to create fake exiftool: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return filepath.Join(dir, "starts")
+}
+
+func countStarts(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read start log: %v", err)
+	}
+	return strings.Count(string(data), "start")
+}
+
+func TestExifTool_Recovery(t *testing.T) {
+	starts := installFakeExifTool(t)
+
+	et, err := NewExifTool(200*time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("NewExifTool() error = %v", err)
+	}
+	defer et.Close()
+
+	// The hung file fails on its own, the rest of the batch still succeeds
+	results, errs := et.ReadTagsBatch([]string{"ok.jpg", "hang.jpg"}, "DateTimeOriginal")
+	if errs[0] != nil || results[0]["DateTimeOriginal"] != "2017:06:08 19:42:41" {
+		t.Errorf("ok.jpg = %v, %v; want DateTimeOriginal", results[0], errs[0])
+	}
+	if !errors.Is(errs[1], errExifToolTimeout) {
+		t.Errorf("hang.jpg error = %v, want errExifToolTimeout", errs[1])
+	}
+
+	// One start, one restart after the batch and two attempts at hang.jpg
+	if got := countStarts(t, starts); got != 3 {
+		t.Errorf("exiftool started %d times, want 3", got)
+	}
+
+	if _, err := et.ReadTags("crash.jpg", "DateTimeOriginal"); !errors.Is(err, errExifToolExited) {
+		t.Errorf("crash.jpg error = %v, want errExifToolExited", err)
+	}
+	if _, err := et.ReadTags("ok.jpg", "DateTimeOriginal"); err != nil {
+		t.Errorf("ReadTags() after crash error = %v, want a restarted process", err)
+	}
+}
+
+func TestExifTool_WriteRecovery(t *testing.T) {
+	installFakeExifTool(t)
+
+	et, err := NewExifTool(200*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("NewExifTool() error = %v", err)
+	}
+	defer et.Close()
+
+	tags := map[string]string{"DateTimeOriginal": "2001:02:03 04:05:06"}
+	errs := et.WriteTagsBatch([]string{"a.jpg", "hang.jpg", "b.jpg"}, []map[string]string{tags, tags, tags})
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("WriteTagsBatch() errors = %v, want only hang.jpg to fail", errs)
+	}
+	if !errors.Is(errs[1], errExifToolTimeout) {
+		t.Errorf("hang.jpg error = %v, want errExifToolTimeout", errs[1])
+	}
+}
+
+func TestExifTool_LargeBatch(t *testing.T) {
+	installFakeExifTool(t)
+
+	et, err := NewExifTool(5*time.Second, 0)
+	if err != nil {
+		t.Fatalf("NewExifTool() error = %v", err)
+	}
+	defer et.Close()
+
+	// Far more output than a pipe holds, produced while the batch is still
+	// being sent
+	filePaths := make([]string, 2000)
+	tags := make([]map[string]string, len(filePaths))
+	for i := range filePaths {
+		filePaths[i] = fmt.Sprintf("%s_%04d.jpg", strings.Repeat("long_name", 20), i)
+		tags[i] = map[string]string{"DateTimeOriginal": "2001:02:03 04:05:06"}
+	}
+
+	errs := et.WriteTagsBatch(filePaths, tags)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("WriteTagsBatch() file %d error = %v", i, err)
+		}
+	}
+	if got := et.TakeMessages(filePaths[len(filePaths)-1]); len(got) != 1 {
+		t.Errorf("TakeMessages(last file) = %v, want its warning", got)
+	}
+}
+
+func TestExifTool_Messages(t *testing.T) {
+	installFakeExifTool(t)
+
+	et, err := NewExifTool(time.Second, 0)
+	if err != nil {
+		t.Fatalf("NewExifTool() error = %v", err)
+	}
+	defer et.Close()
+
+	tags := map[string]string{"DateTimeOriginal": "2001:02:03 04:05:06"}
+	errs := et.WriteTagsBatch([]string{"a.jpg", "image.png.jpg"}, []map[string]string{tags, tags})
+	if errs[0] != nil {
+		t.Errorf("a.jpg error = %v, want nil", errs[0])
+	}
+	if errs[1] == nil || errs[1].Error() != "Not a valid JPG (looks more like a PNG)" {
+		t.Errorf("image.png.jpg error = %v, want exiftool's reason", errs[1])
+	}
+
+	if got := et.TakeMessages("a.jpg"); len(got) != 1 || got[0] != (fileMessage{"warning", "[minor] Fake warning"}) {
+		t.Errorf("TakeMessages(a.jpg) = %v, want the warning", got)
+	}
+	if got := et.TakeMessages("a.jpg"); len(got) != 0 {
+		t.Errorf("TakeMessages(a.jpg) again = %v, want nothing", got)
+	}
+}
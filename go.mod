@@ -1,3 +1,5 @@
 module github.com/bryanbrunetti/exifupdater
 
 go 1.23.1
+
+require golang.org/x/text v0.28.0
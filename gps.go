@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gpsMapDatum is the geodetic datum of the coordinates Google Photos
+// records
+const gpsMapDatum = "WGS-84"
+
+// rawValueTags are handed to exiftool as raw values, bypassing the print
+// conversion that would otherwise expect "North" or "Below Sea Level"
+// rather than N or 1
+var rawValueTags = map[string]bool{
+	"GPSLatitude":             true,
+	"GPSLatitudeRef":          true,
+	"GPSLongitude":            true,
+	"GPSLongitudeRef":         true,
+	"GPSAltitude":             true,
+	"GPSAltitudeRef":          true,
+	"Keys:GPSCoordinates":     true,
+	"UserData:GPSCoordinates": true,
+}
+
+// locationTags returns the tags recording where and, for photos, when in
+// UTC a file was taken, in the form its format stores them. QuickTime
+// containers get the GPSCoordinates key and the ©xyz atom; every other
+// file gets an EXIF GPS block. It returns nil without coordinates.
+func locationTags(mediaPath string, gps geoData, taken time.Time) map[string]string {
+	if gps.Latitude == 0 && gps.Longitude == 0 {
+		return nil
+	}
+	if isQuickTimeExtension(strings.ToLower(filepath.Ext(mediaPath))) {
+		return quickTimeGPSTags(gps)
+	}
+	return exifGPSTags(gps, taken)
+}
+
+// exifGPSTags returns a complete EXIF GPS block: unsigned coordinates with
+// their hemisphere references, the altitude with its sea level reference,
+// the UTC date and time stamps and the map datum
+func exifGPSTags(gps geoData, taken time.Time) map[string]string {
+	utc := taken.UTC()
+	tags := map[string]string{
+		"GPSLatitude":     fmt.Sprintf("%f", math.Abs(gps.Latitude)),
+		"GPSLatitudeRef":  hemisphere(gps.Latitude, "N", "S"),
+		"GPSLongitude":    fmt.Sprintf("%f", math.Abs(gps.Longitude)),
+		"GPSLongitudeRef": hemisphere(gps.Longitude, "E", "W"),
+		"GPSDateStamp":    utc.Format("2006:01:02"),
+		"GPSTimeStamp":    utc.Format("15:04:05"),
+		"GPSMapDatum":     gpsMapDatum,
+	}
+	if gps.Altitude != 0 {
+		tags["GPSAltitude"] = fmt.Sprintf("%f", math.Abs(gps.Altitude))
+		tags["GPSAltitudeRef"] = hemisphere(gps.Altitude, "0", "1")
+	}
+	return tags
+}
+
+// quickTimeGPSTags returns the location of a QuickTime or MP4 video as
+// signed decimal degrees, and metres of altitude when known. exiftool
+// stores the ©xyz atom in its ISO 6709 form.
+func quickTimeGPSTags(gps geoData) map[string]string {
+	coordinates := fmt.Sprintf("%f %f", gps.Latitude, gps.Longitude)
+	if gps.Altitude != 0 {
+		coordinates += fmt.Sprintf(" %f", gps.Altitude)
+	}
+	return map[string]string{
+		"Keys:GPSCoordinates":     coordinates,
+		"UserData:GPSCoordinates": coordinates,
+	}
+}
+
+// hemisphere returns positive for values of zero and above
+func hemisphere(value float64, positive, negative string) string {
+	if value < 0 {
+		return negative
+	}
+	return positive
+}
+
+// isQuickTimeExtension reports whether ext, in lower case, is a QuickTime
+// or ISO media container
+func isQuickTimeExtension(ext string) bool {
+	switch ext {
+	case ".mp4", ".mov", ".m4v", ".3gp":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLocationTags(t *testing.T) {
+	taken := time.Unix(1496965361, 0) // 2017-06-08 23:42:41 UTC
+	newYork := geoData{Latitude: 40.7334367, Longitude: -73.5823593, Altitude: -11.199}
+
+	tests := []struct {
+		name string
+		path string
+		gps  geoData
+		want map[string]string
+	}{
+		{
+			name: "photo below sea level",
+			path: "IMG_0001.jpg",
+			gps:  newYork,
+			want: map[string]string{
+				"GPSLatitude":     "40.733437",
+				"GPSLatitudeRef":  "N",
+				"GPSLongitude":    "73.582359",
+				"GPSLongitudeRef": "W",
+				"GPSAltitude":     "11.199000",
+				"GPSAltitudeRef":  "1",
+				"GPSDateStamp":    "2017:06:08",
+				"GPSTimeStamp":    "23:42:41",
+				"GPSMapDatum":     "WGS-84",
+			},
+		},
+		{
+			name: "southern photo without altitude",
+			path: "IMG_0002.HEIC",
+			gps:  geoData{Latitude: -33.8688, Longitude: 151.2093},
+			want: map[string]string{
+				"GPSLatitude":     "33.868800",
+				"GPSLatitudeRef":  "S",
+				"GPSLongitude":    "151.209300",
+				"GPSLongitudeRef": "E",
+				"GPSDateStamp":    "2017:06:08",
+				"GPSTimeStamp":    "23:42:41",
+				"GPSMapDatum":     "WGS-84",
+			},
+		},
+		{
+			name: "QuickTime video",
+			path: "IMG_0001.MOV",
+			gps:  newYork,
+			want: map[string]string{
+				"Keys:GPSCoordinates":     "40.733437 -73.582359 -11.199000",
+				"UserData:GPSCoordinates": "40.733437 -73.582359 -11.199000",
+			},
+		},
+		{
+			name: "MP4 without altitude",
+			path: "PXL_20210101_101010123.mp4",
+			gps:  geoData{Latitude: 48.85837, Longitude: 2.29448},
+			want: map[string]string{
+				"Keys:GPSCoordinates":     "48.858370 2.294480",
+				"UserData:GPSCoordinates": "48.858370 2.294480",
+			},
+		},
+		{
+			name: "no coordinates",
+			path: "IMG_0003.jpg",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := locationTags(tt.path, tt.gps, taken)
+			if len(got) != len(tt.want) {
+				t.Fatalf("locationTags() = %v, want %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("locationTags()[%s] = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteArgs_RawValues(t *testing.T) {
+	args := writeArgs("IMG_0001.jpg", map[string]string{
+		"DateTimeOriginal": "2017:06:08 19:42:41",
+		"GPSAltitudeRef":   "1",
+		"GPSLatitudeRef":   "N",
+	})
+	want := []string{"-overwrite_original", "-DateTimeOriginal=2017:06:08 19:42:41", "-GPSAltitudeRef#=1", "-GPSLatitudeRef#=N", "IMG_0001.jpg"}
+	if !slices.Equal(args, want) {
+		t.Errorf("writeArgs() = %q, want %q", args, want)
+	}
+}
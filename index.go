@@ -0,0 +1,299 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// dirIndex maps the normalized names of the files in a directory to their
+// names on disk, in directory order
+type dirIndex map[string][]string
+
+// lookup returns the name on disk of the file called name, or of a media
+// file with the same stem, ignoring Unicode normalization, case and
+// replaced characters. It returns "" when nothing matches.
+func (idx dirIndex) lookup(name string) string {
+	if names := idx[normalizeName(name)]; len(names) > 0 {
+		return names[0]
+	}
+
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, ext := range fallbackExtensions {
+		if names := idx[normalizeName(stem+ext)]; len(names) > 0 {
+			return names[0]
+		}
+	}
+	return ""
+}
+
+// dirListing holds the files of one directory, by exact and by normalized
+// name. Subdirectories are left out.
+type dirListing struct {
+	mu    sync.RWMutex
+	names map[string]bool
+	index dirIndex
+
+	// place is held while a file is moved into the directory, so two
+	// sidecars resolving to the same file do not both move it
+	place sync.Mutex
+}
+
+// listDir reads the files of dir. A directory that cannot be read gives
+// an empty listing along with the error.
+func listDir(dir string) (*dirListing, error) {
+	l := &dirListing{names: make(map[string]bool), index: make(dirIndex)}
+	entries, err := os.ReadDir(dir)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			l.add(entry.Name())
+		}
+	}
+	return l, err
+}
+
+// has reports whether the directory holds a file called exactly name
+func (l *dirListing) has(name string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.names[name]
+}
+
+// files returns the names in the listing, sorted
+func (l *dirListing) files() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, 0, len(l.names))
+	for name := range l.names {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// withPrefix returns the media files whose normalized name starts with the
+// normalized prefix, sorted
+func (l *dirListing) withPrefix(prefix string) []string {
+	key := normalizeName(prefix)
+	var found []string
+	for _, name := range l.files() {
+		if isMediaFile(name) && strings.HasPrefix(normalizeName(name), key) {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// lookup is dirIndex.lookup on the listing
+func (l *dirListing) lookup(name string) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.index.lookup(name)
+}
+
+func (l *dirListing) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.names[name] {
+		return
+	}
+	l.names[name] = true
+	key := normalizeName(name)
+	l.index[key] = append(l.index[key], name)
+}
+
+func (l *dirListing) remove(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.names[name] {
+		return
+	}
+	delete(l.names, name)
+	key := normalizeName(name)
+	l.index[key] = slices.DeleteFunc(l.index[key], func(n string) bool { return n == name })
+	if len(l.index[key]) == 0 {
+		delete(l.index, key)
+	}
+}
+
+// dirCache lists each directory once and shares the listings between
+// workers, so matching a sidecar costs no stat calls once its directory
+// is known. Moves made during a run are applied with added and removed.
+// A nil cache lists the directory again on every call.
+type dirCache struct {
+	mu   sync.Mutex
+	dirs map[string]*cachedDir
+}
+
+type cachedDir struct {
+	once    sync.Once
+	listing *dirListing
+
+	sidecarsOnce sync.Once
+	sidecars     map[string]string // media file name -> its sidecar's name
+}
+
+func newDirCache() *dirCache {
+	return &dirCache{dirs: make(map[string]*cachedDir)}
+}
+
+// listing returns the files of dir, reading the directory the first time
+func (c *dirCache) listing(dir string) *dirListing {
+	if c == nil {
+		l, _ := listDir(dir)
+		return l
+	}
+
+	dir = filepath.Clean(dir)
+	cd := c.entry(dir)
+
+	// Workers asking for the same directory wait for a single read
+	cd.once.Do(func() {
+		cd.listing, _ = listDir(dir)
+	})
+	return cd.listing
+}
+
+// entry returns the cache entry of a cleaned dir, adding it if needed
+func (c *dirCache) entry(dir string) *cachedDir {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cd, ok := c.dirs[dir]
+	if !ok {
+		cd = &cachedDir{}
+		c.dirs[dir] = cd
+	}
+	return cd
+}
+
+// sidecar returns the JSON file next to mediaPath whose name leads to it,
+// or "". The sidecars of a directory are paired with their media files
+// once, from the files it held when first asked.
+func (c *dirCache) sidecar(mediaPath string) string {
+	dir := filepath.Clean(filepath.Dir(mediaPath))
+	var sidecars map[string]string
+	if c == nil {
+		sidecars = pairSidecars(c, dir)
+	} else {
+		cd := c.entry(dir)
+		cd.sidecarsOnce.Do(func() {
+			cd.sidecars = pairSidecars(c, dir)
+		})
+		sidecars = cd.sidecars
+	}
+	if name, ok := sidecars[filepath.Base(mediaPath)]; ok {
+		return filepath.Join(dir, name)
+	}
+	return ""
+}
+
+// pairSidecars maps the media files of dir to the sidecars whose own names,
+// truncated or numbered as Takeout writes them, lead to them. A file two
+// sidecars lead to keeps the first in name order.
+func pairSidecars(files *dirCache, dir string) map[string]string {
+	sidecars := make(map[string]string)
+	for _, name := range files.listing(dir).files() {
+		if filepath.Ext(name) != ".json" || name == "metadata.json" {
+			continue
+		}
+		path, _ := matchMediaFile(files, filepath.Join(dir, name), "")
+		if media := filepath.Base(path); path != "" && sidecars[media] == "" {
+			sidecars[media] = name
+		}
+	}
+	return sidecars
+}
+
+// exists reports whether path names a file
+func (c *dirCache) exists(path string) bool {
+	return c.listing(filepath.Dir(path)).has(filepath.Base(path))
+}
+
+// added records a file created at path
+func (c *dirCache) added(path string) {
+	if l := c.cached(filepath.Dir(path)); l != nil {
+		l.add(filepath.Base(path))
+	}
+}
+
+// removed records that the file at path is gone
+func (c *dirCache) removed(path string) {
+	if l := c.cached(filepath.Dir(path)); l != nil {
+		l.remove(filepath.Base(path))
+	}
+}
+
+// cached returns the listing of dir if it was read already. Directories
+// that were never listed need no update; they are read when first used.
+func (c *dirCache) cached(dir string) *dirListing {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	cd, ok := c.dirs[filepath.Clean(dir)]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	cd.once.Do(func() {
+		cd.listing, _ = listDir(filepath.Clean(dir))
+	})
+	return cd.listing
+}
+
+// yearFolder matches the "Photos from 2017" folders Takeout keeps the
+// originals in
+var yearFolder = regexp.MustCompile(`^Photos from \d{4}$`)
+
+// treeIndex maps the normalized names of the media files of a whole source
+// tree to their paths, so a sidecar in an album folder can find a file
+// that Takeout only put in a year folder
+type treeIndex map[string][]string
+
+// buildTreeIndex indexes every media file under root
+func buildTreeIndex(root string) (treeIndex, error) {
+	tree := make(treeIndex)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() && isMediaFile(info.Name()) {
+			key := normalizeName(info.Name())
+			tree[key] = append(tree[key], path)
+		}
+		return nil
+	})
+	return tree, err
+}
+
+// candidates returns the paths of the files called name, or of media files
+// with the same stem, ignoring normalization as dirIndex.lookup does. Files
+// in year folders come first.
+func (tree treeIndex) candidates(name string) []string {
+	paths := tree[normalizeName(name)]
+	if len(paths) == 0 {
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		for _, ext := range fallbackExtensions {
+			if paths = tree[normalizeName(stem+ext)]; len(paths) > 0 {
+				break
+			}
+		}
+	}
+
+	sorted := slices.Clone(paths)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		aYear := yearFolder.MatchString(filepath.Base(filepath.Dir(a)))
+		bYear := yearFolder.MatchString(filepath.Base(filepath.Dir(b)))
+		switch {
+		case aYear && !bYear:
+			return -1
+		case bYear && !aYear:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return sorted
+}
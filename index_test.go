@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirListing_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Cafe\u0301.JPG", "Party_ 2019_.mp4", "Zoë.heic"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "Album"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	listing, err := listDir(dir)
+	if err != nil {
+		t.Fatalf("listDir() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Café.jpg", "Cafe\u0301.JPG"},
+		{"Party: 2019?.mp4", "Party_ 2019_.mp4"},
+		{"Zoë.jpg", "Zoë.heic"}, // other extension
+		{"Album", ""},
+		{"Cafe.jpg", ""},
+	}
+
+	for _, tt := range tests {
+		if got := listing.lookup(tt.name); got != tt.want {
+			t.Errorf("lookup(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(photo, []byte("image"), 0644); err != nil {
+		t.Fatalf("Failed to create photo: %v", err)
+	}
+
+	files := newDirCache()
+	if !files.exists(photo) {
+		t.Fatal("exists() = false for a file on disk")
+	}
+
+	// The listing is read once: files created behind the cache's back are
+	// not seen, moves reported to it are
+	video := filepath.Join(dir, "video.mp4")
+	if err := os.WriteFile(video, []byte("video"), 0644); err != nil {
+		t.Fatalf("Failed to create video: %v", err)
+	}
+	if files.exists(video) {
+		t.Error("exists() listed the directory again")
+	}
+	files.added(video)
+	files.removed(photo)
+	if !files.exists(video) || files.exists(photo) {
+		t.Errorf("exists() after added/removed = %v, %v, want true, false", files.exists(video), files.exists(photo))
+	}
+	if got := files.listing(dir).lookup("PHOTO.jpg"); got != "" {
+		t.Errorf("lookup() = %q after removal, want none", got)
+	}
+
+	// Directories that were never listed are read when first used
+	other := filepath.Join(t.TempDir(), "sorted")
+	files.added(filepath.Join(other, "photo.jpg"))
+	if files.exists(filepath.Join(other, "photo.jpg")) {
+		t.Error("added() created a listing for a directory that does not exist")
+	}
+
+	var uncached *dirCache
+	if !uncached.exists(video) {
+		t.Error("nil cache exists() = false for a file on disk")
+	}
+}
+
+func TestDirCache_Sidecar(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"IMG_1234.jpg", "IMG_1234.jpg.supplemental-metadata.json",
+		"IMG_1234(1).jpg", "IMG_1234.jpg.supplemental-met(1).json",
+		"IMG_5678.jpg",
+		"metadata.json",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		media string
+		want  string
+	}{
+		{"IMG_1234.jpg", "IMG_1234.jpg.supplemental-metadata.json"},
+		{"IMG_1234(1).jpg", "IMG_1234.jpg.supplemental-met(1).json"},
+		{"IMG_5678.jpg", ""},
+	}
+
+	files := newDirCache()
+	for _, tt := range tests {
+		got := files.sidecar(filepath.Join(dir, tt.media))
+		if got != "" {
+			got = filepath.Base(got)
+		}
+		if got != tt.want {
+			t.Errorf("sidecar(%q) = %q, want %q", tt.media, got, tt.want)
+		}
+	}
+
+	// The pairs are found once per directory
+	if err := os.WriteFile(filepath.Join(dir, "IMG_5678.jpg.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+	files.added(filepath.Join(dir, "IMG_5678.jpg.json"))
+	if got := files.sidecar(filepath.Join(dir, "IMG_5678.jpg")); got != "" {
+		t.Errorf("sidecar() of a file paired before = %q, want \"\"", got)
+	}
+}
+
+func TestTreeIndex_Candidates(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"Summer/IMG_1234.jpg",
+		"Photos from 2017/IMG_1234.jpg",
+		"Photos from 2017/IMG_5678.HEIC",
+		"Photos from 2017/IMG_1234.jpg.json",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	tree, err := buildTreeIndex(root)
+	if err != nil {
+		t.Fatalf("buildTreeIndex() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"IMG_1234.jpg", []string{"Photos from 2017/IMG_1234.jpg", "Summer/IMG_1234.jpg"}},
+		{"img_5678.jpg", []string{"Photos from 2017/IMG_5678.HEIC"}},
+		{"IMG_9999.jpg", nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, path := range tree.candidates(tt.name) {
+			rel, _ := filepath.Rel(root, path)
+			got = append(got, rel)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("candidates(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalFileName is the journal kept in the source directory
+const journalFileName = ".exifupdater-journal.jsonl"
+
+// Journal record states. A fresh run writes journalStarted, which discards
+// the state of earlier runs of the same mode when the journal is loaded.
+// A skipped sidecar cannot be used, e.g. for lack of a timestamp, and is
+// not retried.
+const (
+	journalStarted = "started"
+	journalResumed = "resumed"
+	journalDone    = "done"
+	journalSkipped = "skipped"
+	journalFailed  = "failed"
+)
+
+// journalRecord is one line of the journal
+type journalRecord struct {
+	Mode   string    `json:"mode"`
+	File   string    `json:"file,omitempty"` // JSON sidecar relative to the source directory
+	Status string    `json:"status"`
+	Match  string    `json:"match,omitempty"` // strategy that found the media file
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// journal records the processing state of each JSON sidecar so an
+// interrupted run can be resumed. It is an append-only JSON lines file; the
+// last record for a file wins. A nil journal records nothing.
+type journal struct {
+	mu    sync.Mutex
+	file  *os.File
+	root  string
+	mode  string
+	state map[string]journalRecord
+}
+
+// openJournal opens the journal of sourceDir for a mode. When resuming, the
+// state of the last run is loaded; otherwise a new run is started. A
+// read-only journal only loads state and never writes, as used by dry runs.
+func openJournal(sourceDir, mode string, resume, readOnly bool) (*journal, error) {
+	j := &journal{
+		root:  sourceDir,
+		mode:  mode,
+		state: make(map[string]journalRecord),
+	}
+	path := filepath.Join(sourceDir, journalFileName)
+
+	if resume {
+		if err := j.load(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading journal: %v", err)
+		}
+	}
+	if readOnly {
+		return j, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+
+	marker := journalStarted
+	if resume {
+		marker = journalResumed
+	}
+	if err := j.append(journalRecord{Mode: mode, Status: marker, Time: time.Now()}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *journal) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec journalRecord
+		// A run killed mid-write can leave a partial last line
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Mode != j.mode {
+			continue
+		}
+		switch rec.Status {
+		case journalStarted:
+			clear(j.state)
+		case journalDone, journalSkipped, journalFailed:
+			j.state[rec.File] = rec
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *journal) append(rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(append(data, '\n'))
+	return err
+}
+
+func (j *journal) key(jsonPath string) string {
+	if rel, err := filepath.Rel(j.root, jsonPath); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(jsonPath)
+}
+
+// record stores the outcome of a JSON sidecar
+func (j *journal) record(jsonPath, status, match, detail string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec := journalRecord{Mode: j.mode, File: j.key(jsonPath), Status: status, Match: match, Detail: detail, Time: time.Now()}
+	j.state[rec.File] = rec
+	if j.file == nil {
+		return
+	}
+	if err := j.append(rec); err != nil {
+		log.Printf("Warning: Could not write journal: %v", err)
+	}
+}
+
+// pending drops the files the journal has already completed or skipped and
+// reports how many were done, skipped, failed before and are new
+func (j *journal) pending(jsonPaths []string) (remaining []string, done, skipped, retry, fresh int) {
+	if j == nil {
+		return jsonPaths, 0, 0, 0, len(jsonPaths)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, jsonPath := range jsonPaths {
+		rec, ok := j.state[j.key(jsonPath)]
+		switch {
+		case ok && rec.Status == journalDone:
+			done++
+			continue
+		case ok && rec.Status == journalSkipped:
+			skipped++
+			continue
+		case ok:
+			retry++
+		default:
+			fresh++
+		}
+		remaining = append(remaining, jsonPath)
+	}
+	return remaining, done, skipped, retry, fresh
+}
+
+// failed returns the number of files whose last outcome was a failure
+func (j *journal) failed() int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	count := 0
+	for _, rec := range j.state {
+		if rec.Status == journalFailed {
+			count++
+		}
+	}
+	return count
+}
+
+func (j *journal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestJournal_Resume(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.jpg.json")
+	b := filepath.Join(dir, "album", "b.jpg.json")
+	c := filepath.Join(dir, "c.jpg.json")
+	d := filepath.Join(dir, "d.jpg.json")
+
+	jl, err := openJournal(dir, "update", false, false)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	jl.record(a, journalDone, matchSidecarName, "")
+	jl.record(b, journalFailed, "", "media file not found")
+	jl.record(d, journalSkipped, "", "no timestamp in JSON metadata")
+	jl.Close()
+
+	// A run killed mid-write leaves a partial line behind
+	f, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	f.WriteString(`{"mode":"update","file":"c.jpg.js`)
+	f.Close()
+
+	jl, err = openJournal(dir, "update", true, false)
+	if err != nil {
+		t.Fatalf("openJournal() resume error = %v", err)
+	}
+	defer jl.Close()
+
+	remaining, done, skipped, retry, fresh := jl.pending([]string{a, b, c, d})
+	if !slices.Equal(remaining, []string{b, c}) {
+		t.Errorf("pending() = %v, want [%s %s]", remaining, b, c)
+	}
+	if done != 1 || skipped != 1 || retry != 1 || fresh != 1 {
+		t.Errorf("pending() counts = %d/%d/%d/%d, want 1/1/1/1", done, skipped, retry, fresh)
+	}
+	if got := jl.failed(); got != 1 {
+		t.Errorf("failed() = %d, want 1", got)
+	}
+
+	// Other modes keep their own state
+	sortJournal, err := openJournal(dir, "sort", true, true)
+	if err != nil {
+		t.Fatalf("openJournal() sort error = %v", err)
+	}
+	if remaining, _, _, _, _ := sortJournal.pending([]string{a}); len(remaining) != 1 {
+		t.Errorf("sort pending() = %v, want the update state ignored", remaining)
+	}
+}
+
+func TestJournal_FreshRunResetsState(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.jpg.json")
+
+	jl, err := openJournal(dir, "sort", false, false)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	jl.record(a, journalDone, matchSidecarName, "")
+	jl.Close()
+
+	jl, err = openJournal(dir, "sort", false, false)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	jl.Close()
+
+	jl, err = openJournal(dir, "sort", true, true)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	if remaining, _, _, _, _ := jl.pending([]string{a}); len(remaining) != 1 {
+		t.Errorf("pending() = %v, want state from before the fresh run discarded", remaining)
+	}
+}
+
+func TestJournal_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+
+	jl, err := openJournal(dir, "update", true, true)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	jl.record(filepath.Join(dir, "a.jpg.json"), journalDone, matchSidecarName, "")
+	jl.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, journalFileName)); !os.IsNotExist(err) {
+		t.Error("read-only journal created a file")
+	}
+
+	var nilJournal *journal
+	nilJournal.record("a.jpg.json", journalDone, "", "")
+	if remaining, _, _, _, fresh := nilJournal.pending([]string{"a.jpg.json"}); len(remaining) != 1 || fresh != 1 {
+		t.Errorf("nil journal pending() = %v, want everything pending", remaining)
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -33,127 +33,70 @@ type photoMetadata struct {
 	Timestamp   string  `json:"timestamp"` // Legacy field
 	GeoData     geoData `json:"geoData"`
 	GeoDataExif geoData `json:"geoDataExif"`
+	Description string  `json:"description"`
+	People      []struct {
+		Name string `json:"name"`
+	} `json:"people"`
+
+	GooglePhotosOrigin struct {
+		MobileUpload struct {
+			DeviceType string `json:"deviceType"` // e.g. IOS_PHONE, ANDROID_PHONE
+		} `json:"mobileUpload"`
+	} `json:"googlePhotosOrigin"`
 }
 
-// ExifTool represents a persistent exiftool process
-type ExifTool struct {
-	cmd    *exec.Cmd
-	stdin  io.WriteCloser
-	stdout *bufio.Reader
-}
-
-// NewExifTool starts a new persistent exiftool process
-func NewExifTool() (*ExifTool, error) {
-	cmd := exec.Command("exiftool", "-stay_open", "True", "-@", "-")
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		stdin.Close()
-		return nil, err
+// takenTimestamp returns when the photo was taken in Unix seconds, from
+// the legacy field for older exports
+func (meta photoMetadata) takenTimestamp() string {
+	if meta.PhotoTakenTime.Timestamp != "" {
+		return meta.PhotoTakenTime.Timestamp
 	}
-
-	if err := cmd.Start(); err != nil {
-		stdin.Close()
-		return nil, err
-	}
-
-	return &ExifTool{
-		cmd:    cmd,
-		stdin:  stdin,
-		stdout: bufio.NewReader(stdout),
-	}, nil
+	return meta.Timestamp
 }
 
-// Execute runs a command through the persistent exiftool process
-func (et *ExifTool) Execute(args ...string) (string, error) {
-	// Write command arguments
-	for _, arg := range args {
-		if _, err := fmt.Fprintln(et.stdin, arg); err != nil {
-			return "", err
-		}
-	}
-
-	// End command
-	if _, err := fmt.Fprintln(et.stdin, "-execute"); err != nil {
-		return "", err
-	}
-
-	// Read response
-	var output strings.Builder
-	for {
-		line, err := et.stdout.ReadString('\n')
-		if err != nil {
-			return "", err
+// checkTruncatedName looks for the names Takeout shortens long titles to
+func checkTruncatedName(files *dirCache, dir, originalTitle string) string {
+	listing := files.listing(dir)
+	for _, truncated := range truncatedNames(originalTitle) {
+		if listing.has(truncated) {
+			return filepath.Join(dir, truncated)
 		}
-
-		if strings.TrimSpace(line) == "{ready}" {
-			break
-		}
-
-		output.WriteString(line)
 	}
-
-	return strings.TrimSpace(output.String()), nil
+	return ""
 }
 
-// Close terminates the persistent exiftool process
-func (et *ExifTool) Close() error {
-	if _, err := fmt.Fprintln(et.stdin, "-stay_open"); err != nil {
-		return err
-	}
-	if _, err := fmt.Fprintln(et.stdin, "False"); err != nil {
-		return err
-	}
-
-	et.stdin.Close()
-	return et.cmd.Wait()
-}
+// fallbackExtensions are tried in this order when a media file is not found
+// under the extension its title gives
+var fallbackExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".heic", ".mp4", ".mov", ".avi", ".mkv"}
 
-func checkTruncatedName(dir, originalTitle string) string {
-	for _, length := range []int{48, 47, 46} {
-		if len(originalTitle) > length {
-			truncated := originalTitle[:length]
-			fullPath := filepath.Join(dir, truncated)
-			if _, err := os.Stat(fullPath); err == nil {
-				return fullPath
-			}
-		}
-	}
-	return ""
-}
+// findFileWithFallbacks looks for originalTitle in dir, answering every
+// candidate from a single listing of the directory
+func findFileWithFallbacks(files *dirCache, dir, originalTitle string) string {
+	listing := files.listing(dir)
 
-func findFileWithFallbacks(dir, originalTitle string) string {
 	// Try the original title first
-	fullPath := filepath.Join(dir, originalTitle)
-	if _, err := os.Stat(fullPath); err == nil {
-		return fullPath
+	if listing.has(originalTitle) {
+		return filepath.Join(dir, originalTitle)
 	}
 
 	// Try different extensions and cases
 	baseName := strings.TrimSuffix(originalTitle, filepath.Ext(originalTitle))
-	extensions := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".heic", ".mp4", ".mov", ".avi", ".mkv"}
 
-	for _, ext := range extensions {
+	for _, ext := range fallbackExtensions {
 		variants := []string{
 			baseName + ext,
 			baseName + strings.ToUpper(ext),
 		}
 
 		for _, variant := range variants {
-			fullPath := filepath.Join(dir, variant)
-			if _, err := os.Stat(fullPath); err == nil {
-				return fullPath
+			if listing.has(variant) {
+				return filepath.Join(dir, variant)
 			}
 		}
 	}
 
 	// Try truncated names
-	if truncatedPath := checkTruncatedName(dir, originalTitle); truncatedPath != "" {
+	if truncatedPath := checkTruncatedName(files, dir, originalTitle); truncatedPath != "" {
 		return truncatedPath
 	}
 
@@ -226,8 +169,9 @@ func createSymlink(oldname, newname string, dryRun bool) error {
 	return os.Symlink(oldname, newname)
 }
 
-func getDateFromTimestamp(timestamp int64) (year, month, day string) {
-	t := time.Unix(timestamp, 0).UTC()
+// getDateFromTimestamp returns the date of timestamp in loc
+func getDateFromTimestamp(timestamp int64, loc *time.Location) (year, month, day string) {
+	t := time.Unix(timestamp, 0).In(loc)
 	return fmt.Sprintf("%04d", t.Year()), fmt.Sprintf("%02d", int(t.Month())), fmt.Sprintf("%02d", t.Day())
 }
 
@@ -277,6 +221,29 @@ func (pb *progressBar) display(current int64) {
 		bar, current, pb.total, percentage, formatDuration(elapsed), eta)
 }
 
+// receiveBatch blocks for the first job and then takes whatever else is
+// already queued, up to size jobs. It returns nil once jobs is closed.
+func receiveBatch(jobs <-chan string, size int) []string {
+	first, ok := <-jobs
+	if !ok {
+		return nil
+	}
+
+	batch := []string{first}
+	for len(batch) < size {
+		select {
+		case job, ok := <-jobs:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, job)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
 func formatDuration(d time.Duration) string {
 	if d < time.Minute {
 		return fmt.Sprintf("%.0fs", d.Seconds())
@@ -296,6 +263,7 @@ func formatDuration(d time.Duration) string {
 type scanResult struct {
 	filePath string
 	missing  bool
+	messages []fileMessage
 }
 
 func isMediaFile(filename string) bool {
@@ -305,43 +273,40 @@ func isMediaFile(filename string) bool {
 	return slices.Contains(mediaExts, ext)
 }
 
-func isMissingTimestamps(et *ExifTool, filePath string) bool {
-	output, err := et.Execute(
-		"-DateTimeOriginal",
-		"-MediaCreateDate",
-		"-CreationDate",
-		"-TrackCreateDate",
-		"-CreateDate",
-		"-DateTimeDigitized",
-		"-GPSDateStamp",
-		"-DateTime",
-		"-s", "-S",
-		filePath,
-	)
+// timestampTags are the tags that count as date information for a file
+var timestampTags = []string{
+	"DateTimeOriginal",
+	"MediaCreateDate",
+	"CreationDate",
+	"TrackCreateDate",
+	"CreateDate",
+	"ContentCreateDate",
+	"DateTimeDigitized",
+	"GPSDateStamp",
+	"DateTime",
+}
 
+func isMissingTimestamps(backend MetadataBackend, filePath string) bool {
+	dates, err := backend.ReadTags(filePath, timestampTags...)
 	if err != nil {
 		return true
 	}
+	return !hasTimestamp(dates)
+}
 
-	// Check if any timestamp field has a value
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		if strings.Contains(line, ":") && len(line) >= 10 {
-			if line != "-" && !strings.Contains(strings.ToLower(line), "error") {
-				return false
+// hasTimestamp reports whether any timestamp field holds a valid date
+func hasTimestamp(dates map[string]any) bool {
+	for _, tag := range timestampTags {
+		if value, ok := dates[tag].(string); ok {
+			if _, err := parseExifDate(value); err == nil {
+				return true
 			}
 		}
 	}
-
-	return true
+	return false
 }
 
-func performScan(sourceDir string) {
+func performScan(sourceDir string, newBackend backendFactory, batchSize int) {
 	timestamp := time.Now().Format("20060102_150405")
 	logFileName := fmt.Sprintf("missing_timestamps_%s.log", timestamp)
 	logFile, err := os.Create(logFileName)
@@ -384,13 +349,13 @@ func performScan(sourceDir string) {
 	fmt.Printf("Using %d workers for scanning...\n\n", numWorkers)
 
 	pb := newProgressBar(totalFiles)
-	jobs := make(chan string, numWorkers*2)
+	jobs := make(chan string, numWorkers*batchSize)
 	results := make(chan scanResult, totalFiles)
 
 	var wg sync.WaitGroup
 	for i := 1; i <= numWorkers; i++ {
 		wg.Add(1)
-		go scanWorker(i, &wg, jobs, results, pb)
+		go scanWorker(i, &wg, jobs, results, pb, newBackend, batchSize)
 	}
 
 	go func() {
@@ -406,10 +371,14 @@ func performScan(sourceDir string) {
 	}()
 
 	var missingFilePaths []string
+	var warned []scanResult
 	for result := range results {
 		if result.missing {
 			missingFilePaths = append(missingFilePaths, result.filePath)
 		}
+		if len(result.messages) > 0 {
+			warned = append(warned, result)
+		}
 		pb.display(int64(len(missingFilePaths) + totalFiles - len(missingFilePaths)))
 	}
 
@@ -424,6 +393,15 @@ func performScan(sourceDir string) {
 		}
 	}
 
+	if len(warned) > 0 {
+		fmt.Fprintf(logFile, "#\n# Warnings and errors reported while reading\n")
+		for _, result := range warned {
+			for _, m := range result.messages {
+				fmt.Fprintf(logFile, "# %s: %s: %s\n", result.filePath, m.Level, m.Message)
+			}
+		}
+	}
+
 	fmt.Printf("\n=== SCAN RESULTS ===\n")
 	fmt.Printf("Total media files scanned: %d\n", totalFiles)
 	fmt.Printf("Files missing ALL timestamp data: %d\n", missingFiles)
@@ -435,29 +413,43 @@ func performScan(sourceDir string) {
 	}
 }
 
-func scanWorker(id int, wg *sync.WaitGroup, jobs <-chan string, results chan<- scanResult, pb *progressBar) {
+func scanWorker(id int, wg *sync.WaitGroup, jobs <-chan string, results chan<- scanResult, pb *progressBar, newBackend backendFactory, batchSize int) {
 	defer wg.Done()
 
-	et, err := NewExifTool()
+	backend, err := newBackend()
 	if err != nil {
-		log.Printf("Worker %d: Failed to start exiftool: %v", id, err)
+		// Keep draining jobs so this worker's share is reported, not dropped
+		log.Printf("Worker %d: Failed to start metadata backend: %v", id, err)
+		for filePath := range jobs {
+			log.Printf("Worker %d: Failed to read '%s': %v", id, filePath, err)
+			results <- scanResult{filePath: filePath, missing: true}
+			pb.update()
+		}
 		return
 	}
-	defer et.Close()
+	defer backend.Close()
 
-	for filePath := range jobs {
-		missing := isMissingTimestamps(et, filePath)
-		results <- scanResult{
-			filePath: filePath,
-			missing:  missing,
+	for batch := receiveBatch(jobs, batchSize); len(batch) > 0; batch = receiveBatch(jobs, batchSize) {
+		dates, errs := readTagsBatch(backend, batch, timestampTags...)
+		for i, filePath := range batch {
+			if errs[i] != nil && !errors.Is(errs[i], errUnsupportedFormat) {
+				log.Printf("Worker %d: Failed to read '%s': %v", id, filePath, errs[i])
+			}
+		}
+		for i, filePath := range batch {
+			results <- scanResult{
+				filePath: filePath,
+				missing:  errs[i] != nil || !hasTimestamp(dates[i]),
+				messages: takeMessages(backend, filePath),
+			}
+			pb.update()
 		}
-		pb.update()
 	}
 }
 
 // UPDATE MODE FUNCTIONS
 
-func performUpdate(sourceDir string, keepJSON, dryRun bool) {
+func performUpdate(sourceDir string, opts updateOptions) {
 	fmt.Println("UPDATE MODE: Updating EXIF timestamps and GPS data from JSON metadata...")
 
 	var jsonFiles []string
@@ -476,23 +468,30 @@ func performUpdate(sourceDir string, keepJSON, dryRun bool) {
 		log.Fatalf("Error scanning for JSON files: %v", err)
 	}
 
-	totalFiles := len(jsonFiles)
-	fmt.Printf("Found %d JSON files to process\n", totalFiles)
+	fmt.Printf("Found %d JSON files to process\n", len(jsonFiles))
 
+	jl, jsonFiles := resumeJobs(sourceDir, "update", jsonFiles, opts.resume, opts.dryRun)
+	defer jl.Close()
+
+	totalFiles := len(jsonFiles)
 	if totalFiles == 0 {
 		fmt.Println("No JSON files found to process.")
 		return
 	}
 
+	started := time.Now()
+	reportFileName := fmt.Sprintf("update_report_%s.json", started.Format("20060102_150405"))
+	report := &updateReport{}
+
 	pb := newProgressBar(totalFiles)
 	numWorkers := runtime.NumCPU()
-	jobs := make(chan string, numWorkers)
+	jobs := make(chan string, numWorkers*opts.batchSize)
 	var wg sync.WaitGroup
 	var updatedFiles int64
 
 	for i := 1; i <= numWorkers; i++ {
 		wg.Add(1)
-		go updateWorker(i, &wg, jobs, keepJSON, dryRun, pb, &updatedFiles)
+		go updateWorker(i, &wg, jobs, opts, pb, &updatedFiles, report, jl)
 	}
 
 	go func() {
@@ -506,148 +505,393 @@ func performUpdate(sourceDir string, keepJSON, dryRun bool) {
 	pb.display(int64(totalFiles))
 	fmt.Println()
 	fmt.Printf("Update complete! Processed %d JSON files, updated %d files that were missing date information.\n", totalFiles, atomic.LoadInt64(&updatedFiles))
+
+	statuses, withMessages := report.counts()
+	if err := report.write(reportFileName, sourceDir, started); err != nil {
+		log.Printf("Warning: Could not write report %s: %v", reportFileName, err)
+		return
+	}
+	fmt.Printf("Report written to %s (%d failed, %d skipped, %d with warnings or errors)\n", reportFileName, statuses[statusFailed], statuses[statusSkipped], withMessages)
+
+	if cm := opts.matcher.content; cm != nil {
+		reviewFileName := fmt.Sprintf("content_match_review_%s.json", started.Format("20060102_150405"))
+		if err := cm.writeReview(reviewFileName); err != nil {
+			log.Printf("Warning: Could not write content match review %s: %v", reviewFileName, err)
+		} else {
+			fmt.Printf("%s (review them in %s)\n", cm.summary(), reviewFileName)
+		}
+	}
+	reportRemaining(jl)
 }
 
-func updateWorker(id int, wg *sync.WaitGroup, jobs <-chan string, keepJSON, dryRun bool, pb *progressBar, updatedFiles *int64) {
-	defer wg.Done()
+// updateJob is a media file paired with the tags to write from its sidecar
+type updateJob struct {
+	jsonPath  string
+	imagePath string
+	match     string // strategy that found the media file
+	gps       geoData
+	tags      map[string]string
+
+	// unwritable is set when the file's container cannot store the tags
+	unwritable error
+
+	// The XMP sidecar written instead of the tags in -xmp-sidecar mode
+	xmpPath string
+	xmp     []byte
+}
+
+// updateOptions are the settings of an update run its workers share. A nil
+// xmp writes into the media files and nil backups keep no originals.
+type updateOptions struct {
+	keepJSON   bool
+	dryRun     bool
+	resume     bool
+	newBackend backendFactory
+	batchSize  int
+	matcher    *mediaMatcher
+	zones      *zoneRules
+	xmp        *xmpSidecars
+	backups    *backupStore
+}
+
+// errUnusableSidecar is returned for JSON files without a usable timestamp
+var errUnusableSidecar = errors.New("unusable JSON metadata")
 
-	var et *ExifTool
-	var err error
+// sidecarOutcome sums up the media files of one sidecar within a batch
+type sidecarOutcome struct {
+	match   string
+	updated bool
+	skipped string // why the sidecar cannot be used
+	failure string
+}
+
+func updateWorker(id int, wg *sync.WaitGroup, jobs <-chan string, opts updateOptions, pb *progressBar, updatedFiles *int64, report *updateReport, jl *journal) {
+	defer wg.Done()
 
-	et, err = NewExifTool()
+	backend, err := opts.newBackend()
 	if err != nil {
-		log.Printf("Worker %d: Failed to start exiftool: %v", id, err)
+		// Keep draining jobs so this worker's share is reported, not dropped
+		log.Printf("Worker %d: Failed to start metadata backend: %v", id, err)
+		for jsonPath := range jobs {
+			report.add(reportEntry{JSONFile: jsonPath, Status: statusFailed, Detail: err.Error()})
+			jl.record(jsonPath, journalFailed, "", err.Error())
+			pb.update()
+		}
 		return
 	}
-	defer et.Close()
+	defer backend.Close()
+
+	for batch := receiveBatch(jobs, opts.batchSize); len(batch) > 0; batch = receiveBatch(jobs, opts.batchSize) {
+		// A sidecar covers its media file and any edited variants of it
+		outcomes := make(map[string]*sidecarOutcome, len(batch))
+		record := func(entry reportEntry) {
+			report.add(entry)
+			o := outcomes[entry.JSONFile]
+			switch entry.Status {
+			case statusUpdated, statusWouldUpdate:
+				o.updated = true
+				atomic.AddInt64(updatedFiles, 1)
+			case statusFailed:
+				if o.failure == "" {
+					o.failure = entry.Detail
+				}
+			}
+		}
 
-	for jsonPath := range jobs {
-		file, err := os.Open(jsonPath)
-		if err != nil {
-			log.Printf("Worker %d: Error opening %s: %v", id, jsonPath, err)
-			continue
+		var pending []updateJob
+		for _, jsonPath := range batch {
+			sidecarJobs, err := prepareUpdate(id, jsonPath, opts.matcher, opts.zones, opts.xmp, backend)
+			if err != nil {
+				// Sidecars that can never be used are not retried by -resume
+				if errors.Is(err, errUnusableSidecar) {
+					outcomes[jsonPath] = &sidecarOutcome{skipped: err.Error()}
+				} else {
+					outcomes[jsonPath] = &sidecarOutcome{failure: err.Error()}
+				}
+				report.add(reportEntry{JSONFile: jsonPath, Status: statusSkipped, Detail: err.Error()})
+				continue
+			}
+			outcomes[jsonPath] = &sidecarOutcome{match: sidecarJobs[0].match}
+			for _, job := range sidecarJobs {
+				if job.unwritable != nil {
+					if opts.dryRun {
+						log.Printf("[DRY RUN] Skipping %s - %v", job.imagePath, job.unwritable)
+					}
+					record(reportEntry{
+						JSONFile:  job.jsonPath,
+						MediaFile: job.imagePath,
+						Match:     job.match,
+						Status:    statusSkipped,
+						Detail:    job.unwritable.Error(),
+					})
+					continue
+				}
+				pending = append(pending, job)
+			}
 		}
 
-		byteValue, err := io.ReadAll(file)
-		file.Close()
-		if err != nil {
-			log.Printf("Worker %d: Error reading %s: %v", id, jsonPath, err)
-			continue
+		// Only update files that are missing ALL date information. Sidecars
+		// leave the media file alone, so they are written whatever it holds.
+		imagePaths := make([]string, len(pending))
+		for i, job := range pending {
+			imagePaths[i] = job.imagePath
+		}
+		var dates []map[string]any
+		var readErrs []error
+		if len(pending) > 0 && opts.xmp == nil {
+			dates, readErrs = readTagsBatch(backend, imagePaths, timestampTags...)
 		}
 
-		var meta photoMetadata
-		if err := json.Unmarshal(byteValue, &meta); err != nil {
-			log.Printf("Worker %d: Error unmarshaling %s: %v", id, jsonPath, err)
-			continue
+		var toWrite []updateJob
+		for i, job := range pending {
+			var detail string
+			switch {
+			case opts.xmp != nil:
+				detail = opts.xmp.conflict(job)
+			case readErrs[i] == nil && hasTimestamp(dates[i]):
+				detail = "already has date information"
+			}
+			if detail != "" {
+				if opts.dryRun {
+					log.Printf("[DRY RUN] Skipping %s - %s", job.imagePath, detail)
+				}
+				record(reportEntry{
+					JSONFile:  job.jsonPath,
+					MediaFile: job.imagePath,
+					Match:     job.match,
+					Status:    statusSkipped,
+					Detail:    detail,
+					Messages:  takeMessages(backend, job.imagePath),
+				})
+				continue
+			}
+			toWrite = append(toWrite, job)
 		}
 
-		timestampStr := meta.PhotoTakenTime.Timestamp
-		if timestampStr == "" {
-			timestampStr = meta.Timestamp // Try legacy field
+		if opts.dryRun {
+			for _, job := range toWrite {
+				logMsg := fmt.Sprintf("[DRY RUN] Would update EXIF timestamps for %s", job.imagePath)
+				if opts.xmp != nil {
+					logMsg = fmt.Sprintf("[DRY RUN] Would write timestamps to %s", job.xmpPath)
+				}
+				if job.gps.Latitude != 0 || job.gps.Longitude != 0 {
+					logMsg += fmt.Sprintf(" and GPS coordinates (%.6f, %.6f", job.gps.Latitude, job.gps.Longitude)
+					if job.gps.Altitude != 0 {
+						logMsg += fmt.Sprintf(", altitude: %.1fm", job.gps.Altitude)
+					}
+					logMsg += ")"
+				}
+				log.Print(logMsg)
+
+				record(reportEntry{
+					JSONFile:  job.jsonPath,
+					MediaFile: job.imagePath,
+					Match:     job.match,
+					Status:    statusWouldUpdate,
+					Messages:  takeMessages(backend, job.imagePath),
+				})
+			}
+			toWrite = nil
 		}
 
-		if meta.Title == "" || timestampStr == "" {
-			continue
+		// A file whose original could not be saved is not touched
+		backedUp := toWrite[:0]
+		for _, job := range toWrite {
+			if err := opts.backups.save(job.imagePath); err != nil {
+				log.Printf("Worker %d: Failed to update '%s': %v", id, job.imagePath, err)
+				record(reportEntry{JSONFile: job.jsonPath, MediaFile: job.imagePath, Match: job.match, Status: statusFailed, Detail: err.Error()})
+				continue
+			}
+			backedUp = append(backedUp, job)
 		}
+		toWrite = backedUp
 
-		imagePath := findFileWithFallbacks(filepath.Dir(jsonPath), meta.Title)
-		if imagePath == "" {
-			continue
+		writePaths := make([]string, len(toWrite))
+		writeTags := make([]map[string]string, len(toWrite))
+		for i, job := range toWrite {
+			writePaths[i], writeTags[i] = job.imagePath, job.tags
+		}
+		var writeErrs []error
+		switch {
+		case len(toWrite) == 0:
+		case opts.xmp != nil:
+			writeErrs = opts.xmp.write(toWrite)
+		default:
+			writeErrs = writeTagsBatch(backend, writePaths, writeTags)
 		}
 
-		// Only update files that are missing ALL date information
-		if !isMissingTimestamps(et, imagePath) {
-			if dryRun {
-				log.Printf("[DRY RUN] Skipping %s - already has date information", imagePath)
+		for i, job := range toWrite {
+			entry := reportEntry{
+				JSONFile:  job.jsonPath,
+				MediaFile: job.imagePath,
+				Match:     job.match,
+				Status:    statusUpdated,
+				Messages:  takeMessages(backend, job.imagePath),
 			}
-			pb.update()
-			continue
+			if writeErrs[i] != nil {
+				log.Printf("Worker %d: Failed to update '%s': %v", id, job.imagePath, writeErrs[i])
+				entry.Status, entry.Detail = statusFailed, writeErrs[i].Error()
+			}
+			record(entry)
 		}
 
-		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
-		if err != nil {
-			continue
+		for _, jsonPath := range batch {
+			finishSidecar(id, jsonPath, outcomes[jsonPath], opts.keepJSON, opts.dryRun, jl)
+			pb.update()
 		}
+	}
+}
 
-		if !dryRun {
-			t := time.Unix(timestamp, 0)
-			formattedTime := t.Format("2006:01:02 15:04:05")
+// prepareUpdate reads a JSON sidecar, locates its media file, any edited
+// variants of it and the video half of a Live Photo and builds the tags to
+// write. The first job is the file the sidecar names. Sidecars that can
+// never be used get an error wrapping errUnusableSidecar; other errors,
+// such as a media file that is not found, may go away on a later run.
+func prepareUpdate(id int, jsonPath string, matcher *mediaMatcher, zones *zoneRules, xmp *xmpSidecars, backend MetadataBackend) ([]updateJob, error) {
+	file, err := os.Open(jsonPath)
+	if err != nil {
+		log.Printf("Worker %d: Error opening %s: %v", id, jsonPath, err)
+		return nil, err
+	}
 
-			// Build command arguments starting with timestamp data
-			args := []string{"-overwrite_original"}
-			dateTimeArg := fmt.Sprintf("-CreateDate=%s", formattedTime)
-			args = append(args, dateTimeArg)
-			dateTimeOrigArg := fmt.Sprintf("-DateTimeOriginal=%s", formattedTime)
-			args = append(args, dateTimeOrigArg)
+	byteValue, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		log.Printf("Worker %d: Error reading %s: %v", id, jsonPath, err)
+		return nil, err
+	}
 
-			// Add GPS data if available
-			var gpsData geoData
-			if meta.GeoDataExif.Latitude != 0 || meta.GeoDataExif.Longitude != 0 {
-				gpsData = meta.GeoDataExif
-			} else if meta.GeoData.Latitude != 0 || meta.GeoData.Longitude != 0 {
-				gpsData = meta.GeoData
-			}
+	var meta photoMetadata
+	if err := json.Unmarshal(byteValue, &meta); err != nil {
+		log.Printf("Worker %d: Error unmarshaling %s: %v", id, jsonPath, err)
+		return nil, fmt.Errorf("%w: %v", errUnusableSidecar, err)
+	}
 
-			if gpsData.Latitude != 0 || gpsData.Longitude != 0 {
-				gpsLatArg := fmt.Sprintf("-GPSLatitude=%f", gpsData.Latitude)
-				gpsLonArg := fmt.Sprintf("-GPSLongitude=%f", gpsData.Longitude)
-				args = append(args, gpsLatArg, gpsLonArg)
+	timestampStr := meta.takenTimestamp()
+	if timestampStr == "" {
+		return nil, fmt.Errorf("%w: no timestamp in JSON metadata", errUnusableSidecar)
+	}
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp %q", errUnusableSidecar, timestampStr)
+	}
 
-				if gpsData.Altitude != 0 {
-					gpsAltArg := fmt.Sprintf("-GPSAltitude=%f", gpsData.Altitude)
-					args = append(args, gpsAltArg)
-				}
-			}
+	imagePath, match := matcher.find(jsonPath, meta)
+	if imagePath == "" {
+		imagePath, match = matcher.matchContent(backend, jsonPath, meta)
+	}
+	if imagePath == "" {
+		return nil, fmt.Errorf("media file %q not found", sidecarMediaName(filepath.Base(jsonPath)))
+	}
 
-			args = append(args, imagePath)
-			_, err := et.Execute(args...)
-			if err != nil {
-				log.Printf("Worker %d: Exiftool command failed for '%s': %v", id, imagePath, err)
-				continue
-			}
-		} else {
-			logMsg := fmt.Sprintf("[DRY RUN] Would update EXIF timestamps for %s", imagePath)
-
-			// Check for GPS data to include in dry run message
-			var gpsData geoData
-			if meta.GeoDataExif.Latitude != 0 || meta.GeoDataExif.Longitude != 0 {
-				gpsData = meta.GeoDataExif
-			} else if meta.GeoData.Latitude != 0 || meta.GeoData.Longitude != 0 {
-				gpsData = meta.GeoData
-			}
+	// Dates are written as local time where the photo was taken. Without
+	// coordinates the timezone rules decide, or else the machine's zone is
+	// the best guess.
+	gpsData := meta.location()
+	t := time.Unix(timestamp, 0).In(photoLocation(gpsData, zones.fallback(jsonPath, timestamp, time.Local)))
+
+	// Each file gets the tags its container can store, or an XMP sidecar
+	newJob := func(path, match string) updateJob {
+		job := updateJob{jsonPath: jsonPath, imagePath: path, match: match, gps: gpsData}
+		if xmp != nil {
+			job.xmpPath, job.xmp = xmp.path(path), xmpPacket(meta, t, gpsData, xmp.albums.lookup(meta))
+			return job
+		}
+		job.tags, job.unwritable = mediaTags(path, gpsData, t)
+		return job
+	}
 
-			if gpsData.Latitude != 0 || gpsData.Longitude != 0 {
-				logMsg += fmt.Sprintf(" and GPS coordinates (%.6f, %.6f", gpsData.Latitude, gpsData.Longitude)
-				if gpsData.Altitude != 0 {
-					logMsg += fmt.Sprintf(", altitude: %.1fm", gpsData.Altitude)
-				}
-				logMsg += ")"
-			}
+	// A file reached from several sidecars, e.g. from an album folder and
+	// its year folder, is updated from the first only
+	if owner, ok := matcher.claim(imagePath, jsonPath); !ok {
+		return nil, fmt.Errorf("media file %q is updated from %s", filepath.Base(imagePath), owner)
+	}
 
-			log.Printf(logMsg)
+	jobs := []updateJob{newJob(imagePath, match)}
+	for _, variant := range matcher.editedVariants(imagePath) {
+		if _, ok := matcher.claim(variant, jsonPath); !ok {
+			continue
+		}
+		jobs = append(jobs, newJob(variant, matchEditedVariant))
+	}
+	if companion := matcher.liveCompanion(imagePath); companion != "" {
+		if _, ok := matcher.claim(companion, jsonPath); ok {
+			jobs = append(jobs, newJob(companion, matchLiveCompanion))
 		}
+	}
+	return jobs, nil
+}
 
-		// Track files that were actually updated (or would be updated in dry-run)
-		atomic.AddInt64(updatedFiles, 1)
+// finishSidecar journals the outcome of a sidecar and removes it once all
+// of its media files were updated
+func finishSidecar(id int, jsonPath string, o *sidecarOutcome, keepJSON, dryRun bool, jl *journal) {
+	if o.skipped != "" {
+		jl.record(jsonPath, journalSkipped, o.match, o.skipped)
+		return
+	}
+	if o.failure != "" {
+		jl.record(jsonPath, journalFailed, o.match, o.failure)
+		return
+	}
+	jl.record(jsonPath, journalDone, o.match, "")
 
-		if !keepJSON && !dryRun {
-			if err := os.Remove(jsonPath); err != nil {
-				log.Printf("Worker %d: Warning: Could not delete JSON file %s: %v", id, jsonPath, err)
-			}
-		} else if !keepJSON && dryRun {
-			log.Printf("[DRY RUN] Would delete JSON file %s", jsonPath)
+	if !o.updated || keepJSON {
+		return
+	}
+	if dryRun {
+		log.Printf("[DRY RUN] Would delete JSON file %s", jsonPath)
+		return
+	}
+	if err := os.Remove(jsonPath); err != nil {
+		log.Printf("Worker %d: Warning: Could not delete JSON file %s: %v", id, jsonPath, err)
+	}
+}
+
+// resumeJobs opens the journal for a mode. When resuming, the JSON files a
+// previous run completed are dropped from the list.
+func resumeJobs(sourceDir, mode string, jsonFiles []string, resume, dryRun bool) (*journal, []string) {
+	jl, err := openJournal(sourceDir, mode, resume, dryRun)
+	if err != nil {
+		if resume {
+			log.Fatalf("Error: Could not open journal: %v", err)
 		}
+		log.Printf("Warning: Could not open journal, this run cannot be resumed: %v", err)
+		return nil, jsonFiles
+	}
 
-		pb.update()
+	if !resume {
+		return jl, jsonFiles
+	}
+	remaining, done, skipped, retry, fresh := jl.pending(jsonFiles)
+	fmt.Printf("Resuming: %d already done, %d skipped, %d failed before and will be retried, %d not processed yet\n", done, skipped, retry, fresh)
+	return jl, remaining
+}
+
+// reportRemaining tells how much work is left for a -resume run
+func reportRemaining(jl *journal) {
+	if failed := jl.failed(); failed > 0 {
+		fmt.Printf("%d JSON files could not be processed; run again with -resume to retry them\n", failed)
 	}
 }
 
 // SORT MODE FUNCTIONS
 
-func performSort(sourceDir, destDir string, keepFiles, dryRun bool) {
+func performSort(sourceDir, destDir string, keepFiles, dryRun, resume bool, matcher *mediaMatcher, zones *zoneRules) {
 	fmt.Println("SORT MODE: Organizing files into date-based structure with album symlinks...")
 
-	if err := ensureDirectory(destDir, dryRun); err != nil {
+	// Every change is logged so the run can be reversed with -undo
+	var oplog *operationLog
+	if !dryRun {
+		logFileName := fmt.Sprintf("sort_operations_%s.jsonl", time.Now().Format("20060102_150405"))
+		var err error
+		if oplog, err = createOperationLog(logFileName); err != nil {
+			log.Fatalf("Error creating operation log: %v", err)
+		}
+		defer oplog.Close()
+		fmt.Printf("Recording operations in %s (reverse them with -undo %s)\n", logFileName, logFileName)
+	}
+
+	if err := oplog.ensureDirectory(destDir, dryRun); err != nil {
 		log.Fatalf("Error: Could not create destination directory %s: %v", destDir, err)
 	}
 
@@ -667,9 +911,12 @@ func performSort(sourceDir, destDir string, keepFiles, dryRun bool) {
 		log.Fatalf("Error scanning for JSON files: %v", err)
 	}
 
-	totalFiles := len(jsonFiles)
-	fmt.Printf("Found %d JSON files to process\n", totalFiles)
+	fmt.Printf("Found %d JSON files to process\n", len(jsonFiles))
+
+	jl, jsonFiles := resumeJobs(sourceDir, "sort", jsonFiles, resume, dryRun)
+	defer jl.Close()
 
+	totalFiles := len(jsonFiles)
 	if totalFiles == 0 {
 		fmt.Println("No JSON files found to process.")
 		return
@@ -682,7 +929,7 @@ func performSort(sourceDir, destDir string, keepFiles, dryRun bool) {
 
 	for i := 1; i <= numWorkers; i++ {
 		wg.Add(1)
-		go sortWorker(i, &wg, jobs, destDir, keepFiles, dryRun, pb)
+		go sortWorker(i, &wg, jobs, destDir, keepFiles, dryRun, matcher, zones, pb, jl, oplog)
 	}
 
 	go func() {
@@ -696,143 +943,153 @@ func performSort(sourceDir, destDir string, keepFiles, dryRun bool) {
 	pb.display(int64(totalFiles))
 	fmt.Println()
 	fmt.Printf("Sort complete! Processed %d JSON files.\n", totalFiles)
+	reportRemaining(jl)
 }
 
-func sortWorker(id int, wg *sync.WaitGroup, jobs <-chan string, destDir string, keepFiles, dryRun bool, pb *progressBar) {
+func sortWorker(id int, wg *sync.WaitGroup, jobs <-chan string, destDir string, keepFiles, dryRun bool, matcher *mediaMatcher, zones *zoneRules, pb *progressBar, jl *journal, oplog *operationLog) {
 	defer wg.Done()
 
 	for jsonPath := range jobs {
-		file, err := os.Open(jsonPath)
-		if err != nil {
-			log.Printf("Worker %d: Error opening %s: %v", id, jsonPath, err)
-			continue
-		}
-
-		byteValue, err := io.ReadAll(file)
-		file.Close()
+		match, err := sortFile(id, jsonPath, destDir, keepFiles, dryRun, matcher, zones, oplog)
 		if err != nil {
-			log.Printf("Worker %d: Error reading %s: %v", id, jsonPath, err)
+			jl.record(jsonPath, journalFailed, match, err.Error())
 			continue
 		}
+		jl.record(jsonPath, journalDone, match, "")
+		pb.update()
+	}
+}
 
-		var meta photoMetadata
-		if err := json.Unmarshal(byteValue, &meta); err != nil {
-			log.Printf("Worker %d: Error unmarshaling %s: %v", id, jsonPath, err)
-			continue
-		}
+// sortFile moves the media file of a JSON sidecar, its edited variants and
+// Live Photo video into the date structure and links them into its album.
+// It returns the strategy that found the file.
+func sortFile(id int, jsonPath, destDir string, keepFiles, dryRun bool, matcher *mediaMatcher, zones *zoneRules, oplog *operationLog) (string, error) {
+	file, err := os.Open(jsonPath)
+	if err != nil {
+		log.Printf("Worker %d: Error opening %s: %v", id, jsonPath, err)
+		return "", err
+	}
 
-		timestampStr := meta.PhotoTakenTime.Timestamp
-		if timestampStr == "" {
-			timestampStr = meta.Timestamp
-		}
+	byteValue, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		log.Printf("Worker %d: Error reading %s: %v", id, jsonPath, err)
+		return "", err
+	}
 
-		if meta.Title == "" || timestampStr == "" {
-			continue
-		}
+	var meta photoMetadata
+	if err := json.Unmarshal(byteValue, &meta); err != nil {
+		log.Printf("Worker %d: Error unmarshaling %s: %v", id, jsonPath, err)
+		return "", err
+	}
 
-		timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
-		if err != nil {
-			continue
-		}
+	timestampStr := meta.takenTimestamp()
+	if timestampStr == "" {
+		return "", errors.New("no timestamp in JSON metadata")
+	}
 
-		year, month, day := getDateFromTimestamp(timestamp)
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp %q", timestampStr)
+	}
 
-		imagePath := findFileWithFallbacks(filepath.Dir(jsonPath), meta.Title)
-		var filename string
-		var destPath string
-		var fileFoundInDateStructure bool
-
-		if imagePath == "" {
-			// File not found locally, check if it exists in date-based structure
-			filename = meta.Title
-			datePath := filepath.Join(destDir, year, month, day)
-			destPath = filepath.Join(datePath, filename)
-
-			if _, err := os.Stat(destPath); err == nil {
-				fileFoundInDateStructure = true
-			} else {
-				// Try different extensions for the file in date structure
-				baseName := strings.TrimSuffix(filename, filepath.Ext(filename))
-				extensions := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".heic", ".mp4", ".mov", ".avi", ".mkv"}
-
-				for _, ext := range extensions {
-					variants := []string{
-						baseName + ext,
-						baseName + strings.ToUpper(ext),
-					}
+	// Photos are filed under their local date where it is known, the same
+	// date update mode writes
+	year, month, day := getDateFromTimestamp(timestamp, photoLocation(meta.location(), zones.fallback(jsonPath, timestamp, time.UTC)))
 
-					for _, variant := range variants {
-						testPath := filepath.Join(datePath, variant)
-						if _, err := os.Stat(testPath); err == nil {
-							filename = variant
-							destPath = testPath
-							fileFoundInDateStructure = true
-							break
-						}
-					}
-					if fileFoundInDateStructure {
-						break
-					}
-				}
-			}
+	datePath := filepath.Join(destDir, year, month, day)
+	imagePath, match := matcher.find(jsonPath, meta)
+	var filenames []string
 
-			if !fileFoundInDateStructure {
-				continue
-			}
-		} else {
-			// File found locally, proceed with normal flow
-			filename = filepath.Base(imagePath)
-			datePath := filepath.Join(destDir, year, month, day)
-			destPath = filepath.Join(datePath, filename)
-
-			// Check if file already exists at destination
-			fileAlreadyExists := false
-			if _, err := os.Stat(destPath); err == nil {
-				fileAlreadyExists = true
-			}
-
-			// Move/copy file to date-based structure
-			if !fileAlreadyExists {
-				if err := moveOrCopyFile(imagePath, destPath, dryRun, keepFiles); err != nil {
-					log.Printf("Worker %d: Error moving/copying file %s to %s: %v", id, imagePath, destPath, err)
-					continue
-				}
-			}
+	if imagePath == "" {
+		// File not found locally, check if an earlier run already moved it
+		// into the date-based structure
+		var destPath string
+		destPath, match = matchMediaFile(matcher.files, filepath.Join(datePath, filepath.Base(jsonPath)), meta.Title)
+		if destPath == "" {
+			return "", fmt.Errorf("media file %q not found", sidecarMediaName(filepath.Base(jsonPath)))
 		}
-
-		// Handle album creation if metadata.json exists
-		metadataJsonPath := filepath.Join(filepath.Dir(jsonPath), "metadata.json")
-		albumName := ""
-
-		if metadataFile, err := os.Open(metadataJsonPath); err == nil {
-			var metadataContent map[string]interface{}
-			decoder := json.NewDecoder(metadataFile)
-			if err := decoder.Decode(&metadataContent); err == nil {
-				if title, ok := metadataContent["title"].(string); ok && title != "" {
-					albumName = title
-				}
+		filenames = append(filenames, filepath.Base(destPath))
+		for _, related := range matcher.relatedFiles(destPath) {
+			filenames = append(filenames, filepath.Base(related))
+		}
+	} else {
+		// File found locally, move it and the files that share its sidecar
+		// next to each other
+		sources := append([]string{imagePath}, matcher.relatedFiles(imagePath)...)
+		for _, source := range sources {
+			if err := placeFile(id, source, datePath, keepFiles, dryRun, matcher.files, oplog); err != nil {
+				return "", err
 			}
-			metadataFile.Close()
+			filenames = append(filenames, filepath.Base(source))
 		}
+	}
 
-		// Create album directory and symlink
-		if albumName != "" {
-			albumDir := filepath.Join(destDir, albumName)
-			if err := ensureDirectory(albumDir, dryRun); err != nil {
-				log.Printf("Worker %d: Error creating album directory %s: %v", id, albumDir, err)
-			} else {
+	// Handle album creation if metadata.json exists
+	albumName := albumTitle(filepath.Dir(jsonPath))
+
+	// Create album directory and symlinks
+	if albumName != "" {
+		albumDir := filepath.Join(destDir, albumName)
+		if err := oplog.ensureDirectory(albumDir, dryRun); err != nil {
+			log.Printf("Worker %d: Error creating album directory %s: %v", id, albumDir, err)
+		} else {
+			for _, filename := range filenames {
 				// Create relative path for symlink: ../<year>/<month>/<day>/<filename>
 				relativePath := filepath.Join("..", year, month, day, filename)
 				symlinkPath := filepath.Join(albumDir, filename)
 
+				previous, _ := os.Readlink(symlinkPath)
 				if err := createSymlink(relativePath, symlinkPath, dryRun); err != nil {
 					log.Printf("Worker %d: Error creating symlink %s -> %s: %v", id, symlinkPath, relativePath, err)
+				} else if previous == "" {
+					oplog.record(sortOperation{Op: opSymlink, Dest: symlinkPath, Target: relativePath})
+				} else {
+					oplog.record(sortOperation{Op: opReplaceSymlink, Dest: symlinkPath, Target: relativePath, Previous: previous})
 				}
 			}
 		}
+	}
 
-		pb.update()
+	return match, nil
+}
+
+// placeFile moves or copies a media file into its date directory unless it
+// is already there
+func placeFile(id int, imagePath, datePath string, keepFiles, dryRun bool, files *dirCache, oplog *operationLog) error {
+	destPath := filepath.Join(datePath, filepath.Base(imagePath))
+
+	// Several sidecars can resolve to the same file; the first one moves it
+	listing := files.listing(datePath)
+	listing.place.Lock()
+	defer listing.place.Unlock()
+	if listing.has(filepath.Base(imagePath)) {
+		return nil
+	}
+
+	if !dryRun {
+		if err := oplog.ensureDirectory(datePath, dryRun); err != nil {
+			log.Printf("Worker %d: Error creating directory %s: %v", id, datePath, err)
+			return err
+		}
+	}
+	if err := moveOrCopyFile(imagePath, destPath, dryRun, keepFiles); err != nil {
+		log.Printf("Worker %d: Error moving/copying file %s to %s: %v", id, imagePath, destPath, err)
+		return err
+	}
+	op := opMove
+	if keepFiles {
+		op = opCopy
 	}
+	oplog.record(sortOperation{Op: op, Source: imagePath, Dest: destPath})
+
+	if !dryRun {
+		files.added(destPath)
+		if !keepFiles {
+			files.removed(imagePath)
+		}
+	}
+	return nil
 }
 
 // MAIN FUNCTION
@@ -845,20 +1102,40 @@ func main() {
 	scanMode := flag.Bool("scan", false, "Scan files to report how many are missing EXIF timestamp data")
 	updateMode := flag.Bool("update", false, "Update EXIF timestamps and GPS coordinates from JSON metadata files")
 	sortMode := flag.Bool("sort", false, "Sort files into date-based directory structure with album symlinks")
+	auditMode := flag.Bool("audit", false, "Pair every media file with its JSON metadata and list the orphans on either side")
+	undoLog := flag.String("undo", "", "Reverse the sort run recorded in the given operation log")
+	restoreDir := flag.String("restore", "", "Put the originals saved in the given backup directory back into the source directory")
 
 	// Options
 	keepJSON := flag.Bool("keep-json", false, "Keep JSON files after processing (don't delete them)")
 	keepFiles := flag.Bool("keep-files", false, "Copy files instead of moving them (preserves originals)")
 	dryRun := flag.Bool("dry-run", false, "Show what would be done without making any changes")
+	backendName := flag.String("backend", "auto", "Metadata backend: auto (built-in with exiftool fallback), native, or exiftool")
+	backupDir := flag.String("backup-dir", "", "Save the original of every file before update mode rewrites it")
+	resume := flag.Bool("resume", false, "Continue an interrupted update or sort, skipping files the journal marks as done")
+	batchSize := flag.Int("batch-size", 16, "Number of files sent to the metadata backend per round-trip")
+	timeout := flag.Duration("timeout", 30*time.Second, "Time to wait for exiftool on a single command before restarting it (0 waits forever)")
+	editedSuffixes := flag.String("edited-suffixes", strings.Join(defaultEditedSuffixes, ","), "Comma separated suffixes of edited copies that share the original's JSON metadata (empty disables)")
+	contentMatch := flag.Bool("content-match", false, "In update mode, pair JSON files whose media file cannot be found by name with a renamed file in the same directory by comparing dates, GPS and type")
+	contentThreshold := flag.Int("content-threshold", 60, "Score from 0 to 100 a content match needs before its metadata is applied")
+	timezone := flag.String("timezone", "", "IANA timezone, e.g. Europe/Berlin, of photos without GPS coordinates (default: the machine's zone in update mode, UTC in sort mode)")
+	timezoneRules := flag.String("timezone-rules", "", "File mapping source folders or date ranges to the timezone of photos without GPS coordinates")
+	xmpSidecar := flag.Bool("xmp-sidecar", false, "In update mode, write an XMP sidecar next to each media file instead of changing the file; JSON files are kept")
+	xmpNaming := flag.String("xmp-naming", xmpNamingExt, "XMP sidecar names: ext (IMG_1234.jpg.xmp, darktable and digiKam) or stem (IMG_1234.xmp, Lightroom)")
+	retries := flag.Int("retries", 2, "Times a command is retried after exiftool hangs or crashes before the file is reported as failed")
 	var destDir string
 	flag.StringVar(&destDir, "dest", "", "Destination directory (required for sort mode)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [mode] [options] <source_directory>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s -undo <operation_log> [-dry-run]\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\nModes (choose exactly one):\n")
 		fmt.Fprintf(os.Stderr, "  -scan    Scan files and report how many are missing EXIF timestamp data\n")
 		fmt.Fprintf(os.Stderr, "  -update  Update EXIF timestamps and GPS coordinates from JSON metadata files\n")
 		fmt.Fprintf(os.Stderr, "  -sort    Sort files into <year>/<month>/<day> structure with album symlinks\n")
+		fmt.Fprintf(os.Stderr, "  -audit   List matched media files, media without JSON and JSON without media\n")
+		fmt.Fprintf(os.Stderr, "  -undo    Reverse a sort run using the operation log it wrote\n")
+		fmt.Fprintf(os.Stderr, "  -restore Put originals saved with -backup-dir back into the source directory\n")
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -866,12 +1143,31 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -update ~/google-takeout\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "  %s -sort -dest ~/organized-photos ~/google-takeout\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "  %s -sort -keep-files -dest ~/organized-photos ~/google-takeout\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "  %s -audit ~/google-takeout\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "  %s -undo sort_operations_20240101_120000.jsonl\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "  %s -update -backup-dir ~/takeout-originals ~/google-takeout\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "  %s -update -xmp-sidecar ~/google-takeout\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "  %s -restore ~/takeout-originals ~/google-takeout\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\nThe sort mode organizes files as:\n")
 		fmt.Fprintf(os.Stderr, "  <dest>/<year>/<month>/<day>/<filename>\n")
 		fmt.Fprintf(os.Stderr, "  <dest>/<album_name>/<filename> (symlinks to date structure)\n")
 	}
 	flag.Parse()
 
+	// Undo only needs the operation log
+	if *undoLog != "" {
+		if *scanMode || *updateMode || *sortMode || *auditMode || *restoreDir != "" {
+			flag.Usage()
+			log.Fatal("Error: You can only specify one mode at a time")
+		}
+		if *dryRun {
+			fmt.Println("🔍 DRY RUN MODE: No files will be modified")
+			fmt.Println()
+		}
+		performUndo(*undoLog, *dryRun)
+		return
+	}
+
 	// Validate arguments
 	if flag.NArg() == 0 {
 		flag.Usage()
@@ -889,10 +1185,16 @@ func main() {
 	if *sortMode {
 		modeCount++
 	}
+	if *auditMode {
+		modeCount++
+	}
+	if *restoreDir != "" {
+		modeCount++
+	}
 
 	if modeCount == 0 {
 		flag.Usage()
-		log.Fatal("Error: You must specify exactly one mode (-scan, -update, or -sort)")
+		log.Fatal("Error: You must specify exactly one mode (-scan, -update, -sort, -audit, -restore, or -undo)")
 	}
 
 	if modeCount > 1 {
@@ -918,11 +1220,89 @@ func main() {
 		log.Fatalf("Error: Provided source path is not a directory: %s", sourceDir)
 	}
 
-	// Check if exiftool is available (except for sort-only mode)
-	if *scanMode || *updateMode {
-		if _, err := exec.LookPath("exiftool"); err != nil {
+	if *batchSize < 1 {
+		flag.Usage()
+		log.Fatal("Error: -batch-size must be at least 1")
+	}
+
+	if *contentThreshold < 0 || *contentThreshold > maxScore {
+		flag.Usage()
+		log.Fatal("Error: -content-threshold must be between 0 and 100")
+	}
+
+	if *timeout < 0 || *retries < 0 {
+		flag.Usage()
+		log.Fatal("Error: -timeout and -retries cannot be negative")
+	}
+
+	if *xmpSidecar && !*updateMode {
+		flag.Usage()
+		log.Fatal("Error: -xmp-sidecar only applies to update mode")
+	}
+	if *xmpSidecar && *backupDir != "" {
+		flag.Usage()
+		log.Fatal("Error: -backup-dir is not needed with -xmp-sidecar, which leaves media files untouched")
+	}
+
+	zones, err := newZoneRules(sourceDir, *timezone, *timezoneRules)
+	if err != nil {
+		flag.Usage()
+		log.Fatalf("Error: %v", err)
+	}
+
+	newBackend, err := newBackendFactory(*backendName, *timeout, *retries)
+	if err != nil {
+		flag.Usage()
+		log.Fatalf("Error: %v (choose auto, native, or exiftool)", err)
+	}
+
+	// Check if exiftool is available. Without it the auto backend falls back
+	// to the built-in reader and the JPEG and MKV writers. Sidecars need no
+	// backend to be written.
+	if *scanMode || (*updateMode && !*xmpSidecar) {
+		_, lookErr := exec.LookPath("exiftool")
+		switch {
+		case lookErr == nil:
+		case *backendName == "exiftool":
 			log.Fatalf("Error: 'exiftool' command not found. Please ensure it is installed and in your system's PATH.")
+		case *backendName == "auto" && *scanMode:
+			fmt.Println("Warning: 'exiftool' not found, using the built-in reader only (JPEG, PNG, HEIC, MP4/MOV)")
+			fmt.Println()
+		case *backendName == "auto" && *updateMode:
+			fmt.Println("Warning: 'exiftool' not found, only JPEG and MKV files will be updated")
+			fmt.Println()
+		}
+	}
+
+	matcher := newMediaMatcher(parseEditedSuffixes(*editedSuffixes))
+	if *contentMatch {
+		matcher.content = newContentMatcher(*contentThreshold)
+	}
+	// Sidecars in album folders may only find their file in a year folder
+	if *updateMode || *sortMode || *auditMode {
+		if err := matcher.indexTree(sourceDir); err != nil {
+			log.Fatalf("Error indexing source directory: %v", err)
+		}
+	}
+
+	var xmp *xmpSidecars
+	if *xmpSidecar {
+		if xmp, err = newXMPSidecars(sourceDir, *xmpNaming); err != nil {
+			flag.Usage()
+			log.Fatalf("Error: %v (choose ext or stem)", err)
+		}
+		// The archive stays as it was
+		*keepJSON = true
+	}
+
+	// Originals are copied aside before they are rewritten
+	var backups *backupStore
+	if *updateMode && *backupDir != "" && !*dryRun {
+		if backups, err = openBackupStore(*backupDir, sourceDir); err != nil {
+			log.Fatalf("Error opening backup directory: %v", err)
 		}
+		defer backups.Close()
+		fmt.Printf("Backing up originals to %s\n", *backupDir)
 	}
 
 	if *dryRun {
@@ -933,10 +1313,24 @@ func main() {
 	// Execute the selected mode
 	switch {
 	case *scanMode:
-		performScan(sourceDir)
+		performScan(sourceDir, newBackend, *batchSize)
 	case *updateMode:
-		performUpdate(sourceDir, *keepJSON, *dryRun)
+		performUpdate(sourceDir, updateOptions{
+			keepJSON:   *keepJSON,
+			dryRun:     *dryRun,
+			resume:     *resume,
+			newBackend: newBackend,
+			batchSize:  *batchSize,
+			matcher:    matcher,
+			zones:      zones,
+			xmp:        xmp,
+			backups:    backups,
+		})
 	case *sortMode:
-		performSort(sourceDir, destDir, *keepFiles, *dryRun)
+		performSort(sourceDir, destDir, *keepFiles, *dryRun, *resume, matcher, zones)
+	case *auditMode:
+		performAudit(sourceDir, matcher)
+	case *restoreDir != "":
+		performRestore(*restoreDir, sourceDir, *dryRun)
 	}
 }
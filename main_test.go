@@ -9,7 +9,7 @@ import (
 )
 
 func TestNewExifTool(t *testing.T) {
-	et, err := NewExifTool()
+	et, err := NewExifTool(30*time.Second, 2)
 	if err != nil {
 		t.Skipf("Skipping test: exiftool not available: %v", err)
 	}
@@ -27,7 +27,7 @@ func TestNewExifTool(t *testing.T) {
 }
 
 func TestExifTool_Execute(t *testing.T) {
-	et, err := NewExifTool()
+	et, err := NewExifTool(30*time.Second, 2)
 	if err != nil {
 		t.Skipf("Skipping test: exiftool not available: %v", err)
 	}
@@ -101,13 +101,13 @@ func TestFindFileWithFallbacks(t *testing.T) {
 	}
 
 	// Test finding the original file
-	got := findFileWithFallbacks(tempDir, testFile)
+	got := findFileWithFallbacks(nil, tempDir, testFile)
 	if got != testPath {
 		t.Errorf("findFileWithFallbacks() = %v, want %v", got, testPath)
 	}
 
 	// Test with non-existent file
-	got = findFileWithFallbacks(tempDir, "nonexistent.jpg")
+	got = findFileWithFallbacks(nil, tempDir, "nonexistent.jpg")
 	if got != "" {
 		t.Errorf("findFileWithFallbacks() with non-existent file = %v, want empty string", got)
 	}
@@ -127,13 +127,13 @@ func TestCheckTruncatedName(t *testing.T) {
 	}
 
 	// Test with original long name that should find the truncated version
-	got := checkTruncatedName(tempDir, longName)
+	got := checkTruncatedName(nil, tempDir, longName)
 	if got != path {
 		t.Errorf("checkTruncatedName() = %v, want %v", got, path)
 	}
 
 	// Test with name that shouldn't match (too short to be truncated)
-	got = checkTruncatedName(tempDir, "short.jpg")
+	got = checkTruncatedName(nil, tempDir, "short.jpg")
 	if got != "" {
 		t.Errorf("checkTruncatedName() with short name = %v, want empty string", got)
 	}
@@ -143,6 +143,7 @@ func TestGetDateFromTimestamp(t *testing.T) {
 	tests := []struct {
 		name      string
 		timestamp int64
+		loc       *time.Location // UTC when nil
 		wantYear  string
 		wantMonth string
 		wantDay   string
@@ -168,11 +169,31 @@ func TestGetDateFromTimestamp(t *testing.T) {
 			wantMonth: "01",
 			wantDay:   "01",
 		},
+		{
+			name:      "Evening in New York",
+			timestamp: 1496965361, // 2017-06-08 23:42:41 UTC
+			loc:       time.FixedZone("EDT", -4*3600),
+			wantYear:  "2017",
+			wantMonth: "06",
+			wantDay:   "08",
+		},
+		{
+			name:      "Next morning in Tokyo",
+			timestamp: 1496965361,
+			loc:       time.FixedZone("JST", 9*3600),
+			wantYear:  "2017",
+			wantMonth: "06",
+			wantDay:   "09",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotYear, gotMonth, gotDay := getDateFromTimestamp(tt.timestamp)
+			loc := time.UTC
+			if tt.loc != nil {
+				loc = tt.loc
+			}
+			gotYear, gotMonth, gotDay := getDateFromTimestamp(tt.timestamp, loc)
 			if gotYear != tt.wantYear {
 				t.Errorf("getDateFromTimestamp() year = %v, want %v", gotYear, tt.wantYear)
 			}
@@ -263,6 +284,23 @@ func TestIsMediaFile(t *testing.T) {
 	}
 }
 
+func TestReceiveBatch(t *testing.T) {
+	jobs := make(chan string, 5)
+	for _, job := range []string{"a", "b", "c", "d", "e"} {
+		jobs <- job
+	}
+	close(jobs)
+
+	var sizes []int
+	for batch := receiveBatch(jobs, 2); len(batch) > 0; batch = receiveBatch(jobs, 2) {
+		sizes = append(sizes, len(batch))
+	}
+
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("receiveBatch() batch sizes = %v, want [2 2 1]", sizes)
+	}
+}
+
 func TestEnsureDirectory(t *testing.T) {
 	tempDir := t.TempDir()
 	testPath := filepath.Join(tempDir, "new", "nested", "directory")
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Strategies that can locate the media file of a sidecar, in the order
+// they are tried
+const (
+	matchSidecarName = "sidecar-name"
+	matchTitle       = "title"
+	matchNormalized  = "normalized-name"
+
+	// matchSourceTree marks a media file found outside the sidecar's
+	// directory, usually in a "Photos from YYYY" folder
+	matchSourceTree = "source-tree"
+
+	// matchSidecarPrefix marks the only media file starting with the name
+	// left in a sidecar name Takeout cut at its length limit
+	matchSidecarPrefix = "sidecar-prefix"
+
+	// matchEditedVariant marks an edited copy found next to the original
+	matchEditedVariant = "edited-variant"
+
+	// matchLiveCompanion marks the video half of a Live or Motion Photo
+	matchLiveCompanion = "live-companion"
+)
+
+// liveStillExtensions are the photo halves of Live and Motion Photos
+var liveStillExtensions = []string{".heic", ".jpg", ".jpeg"}
+
+// liveVideoExtensions are the video halves, tried in this order
+var liveVideoExtensions = []string{".mov", ".MOV", ".mp4", ".MP4"}
+
+// defaultEditedSuffixes are the suffixes Takeout appends to edited copies,
+// e.g. IMG_1234-edited.jpg, in the languages it is known to use
+var defaultEditedSuffixes = []string{
+	"-edited",
+	"-bearbeitet",
+	"-modifié",
+	"-editado",
+	"-modificato",
+	"-bewerkt",
+	"-edytowane",
+	"-redigerad",
+	"-redigeret",
+	"-muokattu",
+}
+
+// supplementalSuffix is the part Takeout inserts between the media name and
+// ".json". Long names get it cut short, down to ".suppl" or less.
+const supplementalSuffix = "supplemental-metadata"
+
+// trailingDuplicate is a "(n)" copy number at the end of a sidecar name
+var trailingDuplicate = regexp.MustCompile(`\((\d+)\)$`)
+
+// duplicateMarker is the "(n)" Takeout adds for the second and later files
+// sharing a name
+var duplicateMarker = regexp.MustCompile(`\((\d+)\)`)
+
+// duplicateIndex returns the copy number encoded in a sidecar name, or 0.
+// Takeout puts the marker in several places for the copy IMG_1234(1).jpg:
+//
+//	IMG_1234.jpg(1).json
+//	IMG_1234(1).jpg.json
+//	IMG_1234(1).jpg.supplemental-metadata.json
+//	IMG_1234.jpg.supplemental-metadata(1).json (or a truncated suffix)
+//
+// A marker that is part of the title itself, as in "Party(1).jpg", is not
+// a copy number.
+func duplicateIndex(jsonName, title string) int {
+	name := strings.TrimSuffix(jsonName, ".json")
+	matches := duplicateMarker.FindAllStringSubmatchIndex(name, -1)
+
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		if rest := name[m[1]:]; rest != "" && rest[0] != '.' {
+			continue
+		}
+		if strings.HasPrefix(name, title) && m[1] <= len(title) {
+			continue
+		}
+		n, err := strconv.Atoi(name[m[2]:m[3]])
+		if err != nil || n == 0 {
+			continue
+		}
+		return n
+	}
+	return 0
+}
+
+// mediaTitle returns the name of the file a sidecar describes. The JSON
+// title of a duplicate still names the first file, so the copy number from
+// the sidecar name is inserted before the extension.
+func mediaTitle(jsonPath, title string) string {
+	n := duplicateIndex(filepath.Base(jsonPath), title)
+	if n == 0 {
+		return title
+	}
+	ext := filepath.Ext(title)
+	return fmt.Sprintf("%s(%d)%s", strings.TrimSuffix(title, ext), n, ext)
+}
+
+// sidecarMediaName derives the media file name from the sidecar's own name,
+// e.g. "IMG_1234.jpg.supplemental-metad(1).json" gives "IMG_1234(1).jpg".
+// Old sidecars named "IMG_1234.json" give "IMG_1234" without an extension.
+func sidecarMediaName(jsonName string) string {
+	name := strings.TrimSuffix(jsonName, ".json")
+
+	var copyNumber string
+	if m := trailingDuplicate.FindStringSubmatchIndex(name); m != nil {
+		copyNumber = name[m[0]:m[1]]
+		name = name[:m[0]]
+	}
+
+	if i := strings.LastIndex(name, "."); i >= 0 && strings.HasPrefix(supplementalSuffix, name[i+1:]) {
+		name = name[:i]
+	}
+
+	if copyNumber != "" {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + copyNumber + ext
+	}
+	return name
+}
+
+// matchMediaFile locates the media file of a sidecar. The sidecar's own
+// name is tried first since the JSON title may have been edited in Google
+// Photos or contain characters the filesystem did not accept. Both names
+// are then compared in normalized form. It returns the path and the
+// strategy that found it.
+func matchMediaFile(files *dirCache, jsonPath, title string) (string, string) {
+	dir := filepath.Dir(jsonPath)
+
+	if name := sidecarMediaName(filepath.Base(jsonPath)); name != "" {
+		if path := findFileWithFallbacks(files, dir, name); path != "" {
+			return path, matchSidecarName
+		}
+	}
+
+	if title != "" {
+		if path := findFileWithFallbacks(files, dir, mediaTitle(jsonPath, title)); path != "" {
+			return path, matchTitle
+		}
+	}
+
+	// Names extracted on macOS are NFD while titles are NFC, and zip tools
+	// replace characters Windows cannot store
+	listing := files.listing(dir)
+	for _, name := range []string{sidecarMediaName(filepath.Base(jsonPath)), mediaTitle(jsonPath, title)} {
+		if name == "" {
+			continue
+		}
+		if found := listing.lookup(name); found != "" {
+			return filepath.Join(dir, found), matchNormalized
+		}
+	}
+
+	// A sidecar name at the length limit may have lost the end of the media
+	// name, extension included
+	if jsonName := filepath.Base(jsonPath); atNameLimit(jsonName) {
+		if prefix := sidecarMediaName(jsonName); prefix != "" {
+			if found := listing.withPrefix(prefix); len(found) == 1 {
+				return filepath.Join(dir, found[0]), matchSidecarPrefix
+			}
+		}
+	}
+	return "", ""
+}
+
+// takeoutNameLimit is the longest file name Takeout writes, extension
+// included
+const takeoutNameLimit = 51
+
+// legacyTruncations are the name lengths, in bytes, older exports cut
+// names to without keeping the extension
+var legacyTruncations = []int{48, 47, 46}
+
+// truncatedNames returns the names Takeout may have given a media file
+// called name when it is too long: the legacy cuts, then the name cut to
+// the limit with its extension kept. The limit is counted in characters
+// and, for exports that count bytes, in bytes without splitting a
+// multibyte character.
+func truncatedNames(name string) []string {
+	var names []string
+	seen := map[string]bool{name: true}
+	addName := func(n string) {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+
+	for _, length := range legacyTruncations {
+		if len(name) > length {
+			addName(name[:length])
+		}
+	}
+
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if keep := takeoutNameLimit - utf8.RuneCountInString(ext); keep > 0 && utf8.RuneCountInString(stem) > keep {
+		addName(string([]rune(stem)[:keep]) + ext)
+	}
+	if keep := takeoutNameLimit - len(ext); keep > 0 && len(stem) > keep {
+		cut := keep
+		for cut > 0 && !utf8.RuneStart(stem[cut]) {
+			cut--
+		}
+		addName(stem[:cut] + ext)
+	}
+	return names
+}
+
+// atNameLimit reports whether name is as long as Takeout allows, counted
+// in characters or in bytes, so its end may have been cut off
+func atNameLimit(name string) bool {
+	return len(name) >= takeoutNameLimit || utf8.RuneCountInString(name) >= takeoutNameLimit
+}
+
+// parseEditedSuffixes splits a comma separated list of edited suffixes
+func parseEditedSuffixes(value string) []string {
+	var suffixes []string
+	for _, suffix := range strings.Split(value, ",") {
+		if suffix = strings.TrimSpace(suffix); suffix != "" {
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	return suffixes
+}
+
+// mediaMatcher locates the media files that belong to a sidecar
+type mediaMatcher struct {
+	editedSuffixes []string
+	files          *dirCache
+	content        *contentMatcher // nil unless content matching is enabled
+	tree           treeIndex       // nil until indexTree is called
+
+	mu      sync.Mutex
+	claimed map[string]string // media file -> sidecar updating it
+}
+
+func newMediaMatcher(editedSuffixes []string) *mediaMatcher {
+	return &mediaMatcher{editedSuffixes: editedSuffixes, files: newDirCache(), claimed: make(map[string]string)}
+}
+
+// indexTree lets find look for media files anywhere under root when the
+// sidecar's own directory has no match
+func (m *mediaMatcher) indexTree(root string) error {
+	tree, err := buildTreeIndex(root)
+	if err != nil {
+		return err
+	}
+	m.tree = tree
+	return nil
+}
+
+// claim reserves mediaPath for the sidecar jsonPath. It returns the sidecar
+// that claimed the file first and whether that was jsonPath.
+func (m *mediaMatcher) claim(mediaPath, jsonPath string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if owner, ok := m.claimed[mediaPath]; ok {
+		return owner, owner == jsonPath
+	}
+	m.claimed[mediaPath] = jsonPath
+	return jsonPath, true
+}
+
+// find returns the media file a sidecar describes and the strategy that
+// found it
+func (m *mediaMatcher) find(jsonPath string, meta photoMetadata) (string, string) {
+	if path, match := matchMediaFile(m.files, jsonPath, meta.Title); path != "" {
+		return path, match
+	}
+	return m.findInTree(jsonPath, meta)
+}
+
+// findInTree looks up the sidecar's media names in the source tree index.
+// Files that were moved away during the run are skipped. Several files
+// sharing a name, such as IMG_1234.JPG in two year folders, are told apart
+// by when the photo was taken; if that leaves anything but one, none is
+// returned.
+func (m *mediaMatcher) findInTree(jsonPath string, meta photoMetadata) (string, string) {
+	if m.tree == nil {
+		return "", ""
+	}
+
+	var names []string
+	for _, name := range []string{sidecarMediaName(filepath.Base(jsonPath)), mediaTitle(jsonPath, meta.Title)} {
+		if name != "" {
+			names = append(names, name)
+			names = append(names, truncatedNames(name)...)
+		}
+	}
+
+	dir := filepath.Dir(jsonPath)
+	for _, name := range names {
+		var found []string
+		for _, path := range m.tree.candidates(name) {
+			if filepath.Dir(path) != dir && m.files.exists(path) {
+				found = append(found, path)
+			}
+		}
+		switch len(found) {
+		case 0:
+			continue
+		case 1:
+			return found[0], matchSourceTree
+		}
+		if path := m.takenAt(found, meta.takenTimestamp()); path != "" {
+			return path, matchSourceTree
+		}
+		return "", ""
+	}
+	return "", ""
+}
+
+// takenAt returns the one file of paths taken at timestamp, or "". A file
+// whose own sidecar has the timestamp fits best; otherwise a file without
+// a sidecar in the year folder of the timestamp does.
+func (m *mediaMatcher) takenAt(paths []string, timestamp string) string {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ""
+	}
+	// The folder's year is that of the local date, up to 14 hours away
+	taken := time.Unix(seconds, 0).UTC()
+	years := []string{strconv.Itoa(taken.Add(-14 * time.Hour).Year()), strconv.Itoa(taken.Add(14 * time.Hour).Year())}
+
+	var bySidecar, byFolder []string
+	for _, path := range paths {
+		if own, ok := m.ownSidecar(path); ok {
+			if own.takenTimestamp() == timestamp {
+				bySidecar = append(bySidecar, path)
+			}
+			continue
+		}
+		folder := filepath.Base(filepath.Dir(path))
+		if yearFolder.MatchString(folder) && slices.Contains(years, folder[len(folder)-4:]) {
+			byFolder = append(byFolder, path)
+		}
+	}
+
+	switch {
+	case len(bySidecar) == 1:
+		return bySidecar[0]
+	case len(bySidecar) == 0 && len(byFolder) == 1:
+		return byFolder[0]
+	}
+	return ""
+}
+
+// ownSidecar returns the metadata of the sidecar next to mediaPath whose
+// name leads to it
+func (m *mediaMatcher) ownSidecar(mediaPath string) (photoMetadata, bool) {
+	jsonPath := m.files.sidecar(mediaPath)
+	if jsonPath == "" {
+		return photoMetadata{}, false
+	}
+	meta, err := readPhotoMetadata(jsonPath)
+	return meta, err == nil
+}
+
+// editedVariants returns the edited copies of a media file. Takeout gives
+// them no sidecar of their own, so they share the original's. A copy
+// number stays at the end: IMG_1234(1).jpg becomes IMG_1234-edited(1).jpg.
+func (m *mediaMatcher) editedVariants(mediaPath string) []string {
+	dir := filepath.Dir(mediaPath)
+	ext := filepath.Ext(mediaPath)
+	stem := strings.TrimSuffix(filepath.Base(mediaPath), ext)
+
+	var copyNumber string
+	if loc := trailingDuplicate.FindStringIndex(stem); loc != nil {
+		copyNumber = stem[loc[0]:]
+	}
+
+	var variants []string
+	seen := map[string]bool{mediaPath: true}
+	for _, suffix := range m.editedSuffixes {
+		names := []string{stem + suffix + ext}
+		if copyNumber != "" {
+			names = append(names, strings.TrimSuffix(stem, copyNumber)+suffix+copyNumber+ext)
+		}
+		for _, name := range names {
+			path := findFileWithFallbacks(m.files, dir, name)
+			if path == "" || seen[path] {
+				continue
+			}
+			seen[path] = true
+			variants = append(variants, path)
+		}
+	}
+	return variants
+}
+
+// liveCompanion returns the video half of a Live Photo (IMG_1234.HEIC and
+// IMG_1234.MOV) or Motion Photo (PXL_..MP.jpg and PXL_..MP.mp4), or "".
+// Takeout exports one sidecar for the pair. A video with a sidecar of its
+// own is not a companion, so no file is updated from two sidecars.
+func (m *mediaMatcher) liveCompanion(mediaPath string) string {
+	ext := filepath.Ext(mediaPath)
+	isStill := false
+	for _, stillExt := range liveStillExtensions {
+		if strings.EqualFold(ext, stillExt) {
+			isStill = true
+			break
+		}
+	}
+	if !isStill {
+		return ""
+	}
+
+	stem := strings.TrimSuffix(mediaPath, ext)
+	stems := []string{stem}
+	if trimmed := strings.TrimSuffix(strings.TrimSuffix(stem, ".MP"), ".mp"); trimmed != stem {
+		stems = append(stems, trimmed)
+	}
+
+	for _, stem := range stems {
+		for _, videoExt := range liveVideoExtensions {
+			path := stem + videoExt
+			if !m.files.exists(path) {
+				continue
+			}
+			if m.files.sidecar(path) != "" {
+				return ""
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// relatedFiles returns the files that share the sidecar of mediaPath: its
+// edited variants and the video half of a Live Photo
+func (m *mediaMatcher) relatedFiles(mediaPath string) []string {
+	related := m.editedVariants(mediaPath)
+	if companion := m.liveCompanion(mediaPath); companion != "" {
+		related = append(related, companion)
+	}
+	return related
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMediaTitle(t *testing.T) {
+	tests := []struct {
+		jsonName string
+		title    string
+		want     string
+	}{
+		{"IMG_1234.jpg.json", "IMG_1234.jpg", "IMG_1234.jpg"},
+		{"IMG_1234.jpg.supplemental-metadata.json", "IMG_1234.jpg", "IMG_1234.jpg"},
+		{"IMG_1234.jpg(1).json", "IMG_1234.jpg", "IMG_1234(1).jpg"},
+		{"IMG_1234.jpg(12).json", "IMG_1234.jpg", "IMG_1234(12).jpg"},
+		{"IMG_1234(1).jpg.json", "IMG_1234.jpg", "IMG_1234(1).jpg"},
+		{"IMG_1234(1).jpg.supplemental-metadata.json", "IMG_1234.jpg", "IMG_1234(1).jpg"},
+		{"IMG_1234.jpg.supplemental-metadata(1).json", "IMG_1234.jpg", "IMG_1234(1).jpg"},
+		{"IMG_1234.jpg.supplemental-metad(2).json", "IMG_1234.jpg", "IMG_1234(2).jpg"},
+		{"IMG_1234.jpg.suppl(1).json", "IMG_1234.jpg", "IMG_1234(1).jpg"},
+		{"IMG_1234(1).json", "IMG_1234.jpg", "IMG_1234(1).jpg"},
+		{"IMG_1234(1).json", "IMG_1234", "IMG_1234(1)"},
+
+		// "(n)" that belongs to the title is kept as is
+		{"Party(1).jpg.supplemental-metadata.json", "Party(1).jpg", "Party(1).jpg"},
+		{"Party(1).jpg(1).json", "Party(1).jpg", "Party(1)(1).jpg"},
+		{"Party(1).jpg.supplemental-metadata(1).json", "Party(1).jpg", "Party(1)(1).jpg"},
+		{"IMG (2).jpg.json", "IMG (2).jpg", "IMG (2).jpg"},
+		{"Trip (2019).mp4.json", "Trip (2019).mp4", "Trip (2019).mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.jsonName, func(t *testing.T) {
+			if got := mediaTitle(filepath.Join("album", tt.jsonName), tt.title); got != tt.want {
+				t.Errorf("mediaTitle(%q, %q) = %q, want %q", tt.jsonName, tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareUpdate_Duplicate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"IMG_1234.jpg", "IMG_1234(1).jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	sidecar := []byte(`{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`)
+	jsonPath := filepath.Join(dir, "IMG_1234.jpg(1).json")
+	if err := os.WriteFile(jsonPath, sidecar, 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+
+	jobs, err := prepareUpdate(1, jsonPath, newMediaMatcher(nil), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("prepareUpdate() error = %v", err)
+	}
+	if want := filepath.Join(dir, "IMG_1234(1).jpg"); jobs[0].imagePath != want {
+		t.Errorf("prepareUpdate() imagePath = %q, want %q", jobs[0].imagePath, want)
+	}
+
+	// Without the copy, the first file of the same name must not be used
+	if err := os.Remove(filepath.Join(dir, "IMG_1234(1).jpg")); err != nil {
+		t.Fatalf("Failed to remove copy: %v", err)
+	}
+	if jobs, err := prepareUpdate(1, jsonPath, newMediaMatcher(nil), nil, nil, nil); err == nil {
+		t.Errorf("prepareUpdate() = %q, want an error instead of the first copy", jobs[0].imagePath)
+	}
+}
+
+func TestSidecarMediaName(t *testing.T) {
+	tests := []struct {
+		jsonName string
+		want     string
+	}{
+		{"IMG_1234.jpg.json", "IMG_1234.jpg"},
+		{"IMG_1234.jpg.supplemental-metadata.json", "IMG_1234.jpg"},
+		{"IMG_1234.jpg.supplemental-metad.json", "IMG_1234.jpg"},
+		{"IMG_1234.jpg.suppl.json", "IMG_1234.jpg"},
+		{"IMG_1234.jpg.s.json", "IMG_1234.jpg"},
+		{"IMG_1234.jpg..json", "IMG_1234.jpg"},
+		{"IMG_1234.json", "IMG_1234"},
+		{"IMG_1234.jpg(1).json", "IMG_1234(1).jpg"},
+		{"IMG_1234(1).jpg.supplemental-metadata.json", "IMG_1234(1).jpg"},
+		{"IMG_1234.jpg.supplemental-metadata(1).json", "IMG_1234(1).jpg"},
+		{"IMG_1234.jpg.supplemental-me(2).json", "IMG_1234(2).jpg"},
+		{"Trip (2019).mp4.supplemental-metadata.json", "Trip (2019).mp4"},
+	}
+
+	for _, tt := range tests {
+		if got := sidecarMediaName(tt.jsonName); got != tt.want {
+			t.Errorf("sidecarMediaName(%q) = %q, want %q", tt.jsonName, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMediaFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"IMG_1234.jpg", "a_b.jpg", "holiday.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		jsonName     string
+		title        string
+		wantFile     string
+		wantStrategy string
+	}{
+		{"title edited in the app", "IMG_1234.jpg.supplemental-metadata.json", "Birthday cake", "IMG_1234.jpg", matchSidecarName},
+		{"title rewritten by the filesystem", "a_b.jpg.suppl.json", "a:b.jpg", "a_b.jpg", matchSidecarName},
+		{"sidecar renamed", "photo-info.json", "holiday.jpg", "holiday.jpg", matchTitle},
+		{"nothing matches", "missing.jpg.json", "missing.jpg", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, strategy := matchMediaFile(nil, filepath.Join(dir, tt.jsonName), tt.title)
+			wantPath := ""
+			if tt.wantFile != "" {
+				wantPath = filepath.Join(dir, tt.wantFile)
+			}
+			if path != wantPath || strategy != tt.wantStrategy {
+				t.Errorf("matchMediaFile() = %q, %q; want %q, %q", path, strategy, wantPath, tt.wantStrategy)
+			}
+		})
+	}
+}
+
+func TestEditedVariants(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"IMG_1234.jpg",
+		"IMG_1234-edited.jpg",
+		"IMG_1234-bearbeitet.JPG",
+		"IMG_1234(1).jpg",
+		"IMG_1234-edited(1).jpg",
+		"IMG_5678.jpg",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	matcher := newMediaMatcher(defaultEditedSuffixes)
+	tests := []struct {
+		media string
+		want  []string
+	}{
+		{"IMG_1234.jpg", []string{"IMG_1234-edited.jpg", "IMG_1234-bearbeitet.JPG"}},
+		{"IMG_1234(1).jpg", []string{"IMG_1234-edited(1).jpg"}},
+		{"IMG_5678.jpg", nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, path := range matcher.editedVariants(filepath.Join(dir, tt.media)) {
+			got = append(got, filepath.Base(path))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("editedVariants(%q) = %v, want %v", tt.media, got, tt.want)
+		}
+	}
+
+	if got := newMediaMatcher(nil).editedVariants(filepath.Join(dir, "IMG_1234.jpg")); len(got) != 0 {
+		t.Errorf("editedVariants() without suffixes = %v, want none", got)
+	}
+}
+
+func TestParseEditedSuffixes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"-edited", []string{"-edited"}},
+		{" -edited , -bearbeitet,", []string{"-edited", "-bearbeitet"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got := parseEditedSuffixes(tt.value)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("parseEditedSuffixes(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLiveCompanion(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"IMG_1234.HEIC", "IMG_1234.MOV", "IMG_1234.HEIC.supplemental-metadata.json",
+		"PXL_20210101_101010123.MP.jpg", "PXL_20210101_101010123.MP.mp4",
+		"PXL_20210202_202020456.MP.jpg", "PXL_20210202_202020456.mp4",
+		"IMG_5678.jpg", "IMG_5678.MOV", "IMG_5678.MOV.supplemental-metadata.json",
+		"IMG_2468.HEIC", "IMG_2468.MOV", "IMG_2468.MOV.supplemental-metad.json",
+		"IMG_1357(1).jpg", "IMG_1357(1).MOV", "IMG_1357.MOV.supplemental-metadata(1).json",
+		"IMG_9012.jpg",
+		"VID_3456.mp4",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		media string
+		want  string
+	}{
+		{"IMG_1234.HEIC", "IMG_1234.MOV"},
+		{"PXL_20210101_101010123.MP.jpg", "PXL_20210101_101010123.MP.mp4"},
+		{"PXL_20210202_202020456.MP.jpg", "PXL_20210202_202020456.mp4"},
+		{"IMG_5678.jpg", ""},    // the video has a sidecar of its own
+		{"IMG_2468.HEIC", ""},   // under a truncated suffix
+		{"IMG_1357(1).jpg", ""}, // under a numbered one
+		{"IMG_9012.jpg", ""},
+		{"VID_3456.mp4", ""},
+	}
+
+	matcher := newMediaMatcher(nil)
+	for _, tt := range tests {
+		got := matcher.liveCompanion(filepath.Join(dir, tt.media))
+		if got != "" {
+			got = filepath.Base(got)
+		}
+		if got != tt.want {
+			t.Errorf("liveCompanion(%q) = %q, want %q", tt.media, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMediaFile_Normalized(t *testing.T) {
+	dir := t.TempDir()
+	// Extracted on macOS: the name on disk is NFD
+	mediaPath := filepath.Join(dir, "Cafe\u0301 de Flore.jpg")
+	if err := os.WriteFile(mediaPath, []byte("image"), 0644); err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+
+	jsonPath := filepath.Join(dir, "Café de Flore.jpg.supplemental-metadata.json")
+	path, strategy := matchMediaFile(nil, jsonPath, "Café de Flore.jpg")
+	if path != mediaPath || strategy != matchNormalized {
+		t.Errorf("matchMediaFile() = %q, %q, want %q, %q", path, strategy, mediaPath, matchNormalized)
+	}
+}
+
+func TestTruncatedNames(t *testing.T) {
+	long := "a_very_long_filename_that_google_cut_off_here_and_more.jpg"
+	multibyte := strings.Repeat("é", 30) + ".jpg" // 30 characters, 60 bytes
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"short.jpg", nil},
+		{long, []string{long[:48], long[:47], long[:46], long[:47] + ".jpg"}},
+		{multibyte, []string{multibyte[:48], multibyte[:47], multibyte[:46], strings.Repeat("é", 23) + ".jpg"}},
+	}
+
+	for _, tt := range tests {
+		got := truncatedNames(tt.name)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("truncatedNames(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMediaFile_Truncated(t *testing.T) {
+	dir := t.TempDir()
+	title := "a_very_long_filename_that_google_cut_off_here_and_more.jpg"
+	mediaName := title[:47] + ".jpg"
+	if err := os.WriteFile(filepath.Join(dir, mediaName), []byte("image"), 0644); err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		jsonName     string
+		title        string
+		wantStrategy string
+	}{
+		{"title cut before the extension", "renamed.json", title, matchTitle},
+		{"sidecar cut at the limit", title[:46] + ".json", "", matchSidecarPrefix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, strategy := matchMediaFile(nil, filepath.Join(dir, tt.jsonName), tt.title)
+			if path != filepath.Join(dir, mediaName) || strategy != tt.wantStrategy {
+				t.Errorf("matchMediaFile() = %q, %q, want %q, %q", path, strategy, mediaName, tt.wantStrategy)
+			}
+		})
+	}
+}
+
+func TestPrepareUpdate_AlbumSidecar(t *testing.T) {
+	root := t.TempDir()
+	sidecar := []byte(`{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`)
+	files := map[string][]byte{
+		"Photos from 2017/IMG_1234.jpg":      []byte("image"),
+		"Photos from 2017/IMG_1234.jpg.json": sidecar,
+		"Summer/IMG_1234.jpg.json":           sidecar,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	matcher := newMediaMatcher(nil)
+	if err := matcher.indexTree(root); err != nil {
+		t.Fatalf("indexTree() error = %v", err)
+	}
+
+	jobs, err := prepareUpdate(1, filepath.Join(root, "Summer", "IMG_1234.jpg.json"), matcher, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("prepareUpdate() error = %v", err)
+	}
+	if want := filepath.Join(root, "Photos from 2017", "IMG_1234.jpg"); jobs[0].imagePath != want || jobs[0].match != matchSourceTree {
+		t.Errorf("prepareUpdate() = %q (%s), want %q from the source tree", jobs[0].imagePath, jobs[0].match, want)
+	}
+
+	// The year folder's own sidecar must not write the same file again
+	if _, err := prepareUpdate(1, filepath.Join(root, "Photos from 2017", "IMG_1234.jpg.json"), matcher, nil, nil, nil); err == nil {
+		t.Error("prepareUpdate() error = nil, want the file claimed by the album sidecar")
+	}
+}
+
+func TestFindInTree_NameCollision(t *testing.T) {
+	sidecar := func(timestamp string) string {
+		return `{"title": "IMG_1234.JPG", "photoTakenTime": {"timestamp": "` + timestamp + `"}}`
+	}
+	const (
+		taken2017 = "1496965361" // 2017-06-08
+		taken2018 = "1528000000" // 2018-06-03
+		taken2019 = "1560000000" // 2019-06-08
+		newYear   = "1546318800" // 2019-01-01 05:00 UTC, still 2018 in New York
+	)
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		taken string
+		want  string
+	}{
+		{
+			name: "year folder",
+			files: map[string]string{
+				"Photos from 2017/IMG_1234.JPG": "image",
+				"Photos from 2019/IMG_1234.JPG": "image",
+			},
+			taken: taken2019,
+			want:  "Photos from 2019/IMG_1234.JPG",
+		},
+		{
+			name: "own sidecar",
+			files: map[string]string{
+				"Photos from 2019/IMG_1234.JPG":      "image",
+				"Photos from 2019/IMG_1234.JPG.json": sidecar(taken2019),
+				"Trip 2017/IMG_1234.JPG":             "image",
+				"Trip 2017/IMG_1234.JPG.json":        sidecar(taken2017),
+			},
+			taken: taken2017,
+			want:  "Trip 2017/IMG_1234.JPG",
+		},
+		{
+			name: "own sidecar of another photo",
+			files: map[string]string{
+				"Photos from 2017/IMG_1234.JPG":      "image",
+				"Photos from 2017/IMG_1234.JPG.json": sidecar(taken2017),
+				"Photos from 2019/IMG_1234.JPG":      "image",
+			},
+			taken: taken2019,
+			want:  "Photos from 2019/IMG_1234.JPG",
+		},
+		{
+			name: "no fit",
+			files: map[string]string{
+				"Photos from 2017/IMG_1234.JPG": "image",
+				"Photos from 2019/IMG_1234.JPG": "image",
+			},
+			taken: taken2018,
+		},
+		{
+			name: "either year at new year",
+			files: map[string]string{
+				"Photos from 2018/IMG_1234.JPG": "image",
+				"Photos from 2019/IMG_1234.JPG": "image",
+			},
+			taken: newYear,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			tt.files["Trip 2019/IMG_1234.JPG.json"] = sidecar(tt.taken)
+			for name, content := range tt.files {
+				path := filepath.Join(root, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatalf("Failed to create %s: %v", name, err)
+				}
+			}
+
+			matcher := newMediaMatcher(nil)
+			if err := matcher.indexTree(root); err != nil {
+				t.Fatalf("indexTree() error = %v", err)
+			}
+			jsonPath := filepath.Join(root, "Trip 2019", "IMG_1234.JPG.json")
+			meta, err := readPhotoMetadata(jsonPath)
+			if err != nil {
+				t.Fatalf("readPhotoMetadata() error = %v", err)
+			}
+
+			want := ""
+			if tt.want != "" {
+				want = filepath.Join(root, filepath.FromSlash(tt.want))
+			}
+			if got, _ := matcher.find(jsonPath, meta); got != want {
+				t.Errorf("find() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// matroskaDateTag is the name exiftool gives the DateUTC element of the
+// segment information. exiftool cannot write Matroska files, so only the
+// native writer sets it.
+const matroskaDateTag = "Matroska:DateTimeOriginal"
+
+// Matroska element IDs, with their length markers
+const (
+	ebmlHeaderID  = 0x1A45DFA3
+	mkvSegmentID  = 0x18538067
+	mkvInfoID     = 0x1549A966
+	mkvClusterID  = 0x1F43B675
+	mkvDateUTCID  = 0x4461
+	mkvVoidID     = 0xEC
+	mkvCRC32ID    = 0xBF
+	mkvDateUTCLen = 11 // ID, size and 8 bytes of data
+)
+
+// matroskaEpoch is the zero point of Matroska dates
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ebmlElement is an element of an EBML file such as Matroska
+type ebmlElement struct {
+	id        uint32
+	start     int64 // offset of the element's ID
+	dataStart int64
+	end       int64
+}
+
+// ebmlVint decodes the variable-length integer b starts with and returns
+// it with its width, or a zero width when b does not start with one. IDs
+// keep their length marker, sizes drop it.
+func ebmlVint(b []byte, keepMarker bool) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	width := bits.LeadingZeros8(b[0]) + 1
+	if width > len(b) {
+		return 0, 0
+	}
+	value := uint64(b[0])
+	if !keepMarker {
+		value &= 0xFF >> width
+	}
+	for _, c := range b[1:width] {
+		value = value<<8 | uint64(c)
+	}
+	return value, width
+}
+
+// encodeEBMLSize encodes an element size in exactly width bytes
+func encodeEBMLSize(size uint64, width int) ([]byte, bool) {
+	if width < 1 || width > 8 || size >= 1<<(7*width)-1 {
+		return nil, false
+	}
+	b := make([]byte, width)
+	size |= 1 << (7 * width)
+	for i := width - 1; i >= 0; i-- {
+		b[i] = byte(size)
+		size >>= 8
+	}
+	return b, true
+}
+
+// readEBMLElement reads the header of the element at pos. An element of
+// unknown size extends to limit.
+func readEBMLElement(r io.ReaderAt, pos, limit int64) (ebmlElement, error) {
+	var buf [12]byte
+	n, _ := r.ReadAt(buf[:], pos)
+	header := buf[:n]
+
+	id, idWidth := ebmlVint(header, true)
+	if idWidth == 0 || idWidth > 4 {
+		return ebmlElement{}, fmt.Errorf("invalid EBML element at offset %d", pos)
+	}
+	size, sizeWidth := ebmlVint(header[idWidth:], false)
+	if sizeWidth == 0 {
+		return ebmlElement{}, fmt.Errorf("invalid EBML element size at offset %d", pos)
+	}
+
+	e := ebmlElement{id: uint32(id), start: pos, dataStart: pos + int64(idWidth+sizeWidth), end: limit}
+	if size != 1<<(7*sizeWidth)-1 {
+		e.end = e.dataStart + int64(size)
+	}
+	if e.end > limit || e.end < e.dataStart {
+		return ebmlElement{}, fmt.Errorf("truncated EBML element at offset %d", pos)
+	}
+	return e, nil
+}
+
+// ebmlChildren returns the elements within parent
+func ebmlChildren(r io.ReaderAt, parent ebmlElement) ([]ebmlElement, error) {
+	var children []ebmlElement
+	for pos := parent.dataStart; pos < parent.end; {
+		child, err := readEBMLElement(r, pos, parent.end)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		pos = child.end
+	}
+	return children, nil
+}
+
+// matroskaInfo locates the segment information of a Matroska file and the
+// element following it, if any. Files that are not EBML give
+// errUnsupportedFormat.
+func matroskaInfo(r io.ReaderAt, size int64) (info, next ebmlElement, err error) {
+	header, err := readEBMLElement(r, 0, size)
+	if err != nil || header.id != ebmlHeaderID {
+		return info, next, errUnsupportedFormat
+	}
+	segment, err := readEBMLElement(r, header.end, size)
+	if err != nil {
+		return info, next, err
+	}
+	if segment.id != mkvSegmentID {
+		return info, next, errors.New("no Matroska segment after the EBML header")
+	}
+
+	for pos := segment.dataStart; pos < segment.end; {
+		e, err := readEBMLElement(r, pos, segment.end)
+		if err != nil {
+			return info, next, err
+		}
+		switch e.id {
+		case mkvInfoID:
+			if e.end < segment.end {
+				next, _ = readEBMLElement(r, e.end, segment.end)
+			}
+			return e, next, nil
+		case mkvClusterID:
+			return info, next, errors.New("no segment information before the first cluster")
+		}
+		pos = e.end
+	}
+	return info, next, errors.New("no segment information")
+}
+
+// readMatroskaDates returns the DateUTC of a Matroska file under the name
+// exiftool uses for it
+func readMatroskaDates(file *os.File) (map[string]string, error) {
+	size, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+	info, _, err := matroskaInfo(file, size)
+	if err != nil {
+		return nil, err
+	}
+	children, err := ebmlChildren(file, info)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]string)
+	for _, child := range children {
+		if child.id != mkvDateUTCID || child.end-child.dataStart != 8 {
+			continue
+		}
+		var data [8]byte
+		if _, err := file.ReadAt(data[:], child.dataStart); err != nil {
+			return nil, err
+		}
+		t := matroskaEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(data[:]))))
+		dates["DateTimeOriginal"] = t.Format("2006:01:02 15:04:05")
+	}
+	return dates, nil
+}
+
+// writeMatroskaTags sets the DateUTC of a Matroska file. An existing
+// element is overwritten; a missing one takes the place of a Void element
+// inside or right after the segment information, which muxers leave for
+// edits like this one. Only the changed bytes are written, so videos are
+// never copied.
+func writeMatroskaTags(filePath string, tags map[string]string) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size, err := fileSize(file)
+	if err != nil {
+		return err
+	}
+	info, next, err := matroskaInfo(file, size)
+	if err != nil {
+		return err
+	}
+	for name := range tags {
+		if name != matroskaDateTag {
+			return fmt.Errorf("%w: %s", errUnsupportedTag, name)
+		}
+	}
+	value, ok := tags[matroskaDateTag]
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return fmt.Errorf("invalid date %q", value)
+	}
+
+	date := make([]byte, 8)
+	binary.BigEndian.PutUint64(date, uint64(t.Sub(matroskaEpoch)))
+
+	children, err := ebmlChildren(file, info)
+	if err != nil {
+		return err
+	}
+	var crc, void ebmlElement
+	for _, child := range children {
+		switch child.id {
+		case mkvDateUTCID:
+			if child.end-child.dataStart != 8 {
+				return fmt.Errorf("invalid DateUTC element of %d bytes", child.end-child.dataStart)
+			}
+			if _, err := file.WriteAt(date, child.dataStart); err != nil {
+				return err
+			}
+			return updateMatroskaCRC(file, crc, info.end)
+		case mkvCRC32ID:
+			crc = child
+		case mkvVoidID:
+			if void.id == 0 && fitsDateUTC(child) {
+				void = child
+			}
+		}
+	}
+
+	infoEnd := info.end
+	if void.id == 0 && next.id == mkvVoidID && fitsDateUTC(next) {
+		// Grow the segment information over the Void that follows it
+		void = next
+		infoEnd += mkvDateUTCLen
+		idWidth := int64(bits.Len32(info.id)+7) / 8
+		sizeWidth := int(info.dataStart - info.start - idWidth)
+		sizeField, ok := encodeEBMLSize(uint64(infoEnd-info.dataStart), sizeWidth)
+		if !ok {
+			return errors.New("segment information size cannot grow in place")
+		}
+		if _, err := file.WriteAt(sizeField, info.start+idWidth); err != nil {
+			return err
+		}
+	}
+	if void.id == 0 {
+		return errors.New("no room for DateUTC without remuxing the file")
+	}
+
+	element := append([]byte{0x44, 0x61, 0x88}, date...)
+	if rest := void.end - void.start - mkvDateUTCLen; rest > 0 {
+		// What is left of the Void stays a Void
+		width := 1
+		if rest-2 > 126 {
+			width = 8
+		}
+		sizeField, _ := encodeEBMLSize(uint64(rest-1-int64(width)), width)
+		element = append(append(element, mkvVoidID), sizeField...)
+	}
+	if _, err := file.WriteAt(element, void.start); err != nil {
+		return err
+	}
+	return updateMatroskaCRC(file, crc, infoEnd)
+}
+
+// fitsDateUTC reports whether a Void element can hold a DateUTC element,
+// leaving either nothing or a valid Void behind
+func fitsDateUTC(void ebmlElement) bool {
+	n := void.end - void.start
+	return n == mkvDateUTCLen || n >= mkvDateUTCLen+2
+}
+
+// updateMatroskaCRC recomputes the CRC-32 element of the segment
+// information, when it has one, over the elements following it up to end
+func updateMatroskaCRC(file *os.File, crc ebmlElement, end int64) error {
+	if crc.id == 0 || crc.end-crc.dataStart != 4 {
+		return nil
+	}
+	data := make([]byte, end-crc.end)
+	if _, err := file.ReadAt(data, crc.end); err != nil {
+		return err
+	}
+	sum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sum, crc32.ChecksumIEEE(data))
+	_, err := file.WriteAt(sum, crc.dataStart)
+	return err
+}
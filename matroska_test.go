@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"testing"
+	"time"
+)
+
+// buildTestEBML builds an element whose size takes sizeWidth bytes
+func buildTestEBML(id uint32, sizeWidth int, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	var idBytes []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(idBytes) > 0 {
+			idBytes = append(idBytes, b)
+		}
+	}
+	size, _ := encodeEBMLSize(uint64(len(data)), sizeWidth)
+	return append(append(idBytes, size...), data...)
+}
+
+func buildTestDateUTC(t time.Time) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(t.Sub(matroskaEpoch)))
+	return buildTestEBML(mkvDateUTCID, 1, data)
+}
+
+// buildTestMatroska builds a file whose segment holds info, the elements
+// after it and a cluster
+func buildTestMatroska(info []byte, afterInfo ...[]byte) []byte {
+	header := buildTestEBML(ebmlHeaderID, 1, buildTestEBML(0x4282, 1, []byte("matroska")))
+	cluster := buildTestEBML(mkvClusterID, 8, []byte{0xE7, 0x81, 0x00})
+	segment := buildTestEBML(mkvSegmentID, 8, info, bytes.Join(afterInfo, nil), cluster)
+	return append(header, segment...)
+}
+
+func TestWriteMatroskaTags(t *testing.T) {
+	old := time.Date(2010, 1, 2, 3, 4, 5, 0, time.UTC)
+	scale := buildTestEBML(0x2AD7B1, 1, []byte{0x0F, 0x42, 0x40})
+	tracks := buildTestEBML(0x1654AE6B, 8)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"existing date", buildTestMatroska(buildTestEBML(mkvInfoID, 8, scale, buildTestDateUTC(old)), tracks), false},
+		{"void in segment information", buildTestMatroska(buildTestEBML(mkvInfoID, 8, scale, buildTestEBML(mkvVoidID, 1, make([]byte, 20))), tracks), false},
+		{"void after segment information", buildTestMatroska(buildTestEBML(mkvInfoID, 8, scale), buildTestEBML(mkvVoidID, 1, make([]byte, 9)), tracks), false},
+		{"large void", buildTestMatroska(buildTestEBML(mkvInfoID, 8, scale), buildTestEBML(mkvVoidID, 8, make([]byte, 4096)), tracks), false},
+		{"checksum", buildTestMatroska(buildTestEBML(mkvInfoID, 8, buildTestEBML(mkvCRC32ID, 1, make([]byte, 4)), scale, buildTestDateUTC(old)), tracks), false},
+		{"void too small", buildTestMatroska(buildTestEBML(mkvInfoID, 8, scale), buildTestEBML(mkvVoidID, 1, make([]byte, 10)), tracks), true},
+		{"no room", buildTestMatroska(buildTestEBML(mkvInfoID, 8, scale), tracks), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, "clip.mkv", tt.data)
+			err := writeMatroskaTags(path, map[string]string{matroskaDateTag: "2017:06:08 23:42:41"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeMatroskaTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			dates, err := nativeReader{}.ReadDates(path)
+			if err != nil {
+				t.Fatalf("ReadDates() error = %v", err)
+			}
+			if dates["DateTimeOriginal"] != "2017:06:08 23:42:41" {
+				t.Errorf("DateTimeOriginal = %q, want 2017:06:08 23:42:41", dates["DateTimeOriginal"])
+			}
+
+			// The rest of the segment must still be in place
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", path, err)
+			}
+			defer file.Close()
+			header, _ := readEBMLElement(file, 0, int64(len(tt.data)))
+			segment, _ := readEBMLElement(file, header.end, int64(len(tt.data)))
+			children, err := ebmlChildren(file, segment)
+			if err != nil {
+				t.Fatalf("ebmlChildren(segment) error = %v", err)
+			}
+			if last := children[len(children)-1]; last.id != mkvClusterID {
+				t.Errorf("last segment element = %#x, want the cluster", last.id)
+			}
+
+			info, _ := ebmlChildren(file, children[0])
+			if info[0].id == mkvCRC32ID {
+				data := make([]byte, children[0].end-info[0].dataStart)
+				file.ReadAt(data, info[0].dataStart)
+				if got, want := binary.LittleEndian.Uint32(data), crc32.ChecksumIEEE(data[4:]); got != want {
+					t.Errorf("CRC-32 = %#x, want %#x", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteMatroskaTags_Unsupported(t *testing.T) {
+	jpeg := writeTestFile(t, "photo.jpg", buildTestJPEG(nil))
+	if err := writeMatroskaTags(jpeg, map[string]string{matroskaDateTag: "2017:06:08 23:42:41"}); !errors.Is(err, errUnsupportedFormat) {
+		t.Errorf("writeMatroskaTags(JPEG) error = %v, want %v", err, errUnsupportedFormat)
+	}
+
+	mkv := writeTestFile(t, "clip.mkv", buildTestMatroska(buildTestEBML(mkvInfoID, 8)))
+	if err := writeMatroskaTags(mkv, map[string]string{"GPSLatitude": "40.733437"}); !errors.Is(err, errUnsupportedTag) {
+		t.Errorf("writeMatroskaTags(GPSLatitude) error = %v, want %v", err, errUnsupportedTag)
+	}
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errUnsupportedFormat is returned by readers that do not understand a file
+var errUnsupportedFormat = errors.New("unsupported file format")
+
+// nativeReader extracts dates from JPEG, PNG, HEIF, QuickTime/MP4 and
+// Matroska files without any external tools
+type nativeReader struct{}
+
+func (nativeReader) ReadDates(filePath string) (map[string]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var magic [12]byte
+	if n, _ := io.ReadFull(file, magic[:]); n < 4 {
+		return nil, errUnsupportedFormat
+	}
+
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		return readJPEGDates(file)
+	case bytes.HasPrefix(magic[:], pngSignature):
+		return readPNGDates(file)
+	case string(magic[4:8]) == "ftyp" && isHEIFBrand(string(magic[8:12])):
+		return readHEIFDates(file)
+	case string(magic[4:8]) == "ftyp" || isQuickTimeAtom(string(magic[4:8])):
+		return readQuickTimeDates(file)
+	case binary.BigEndian.Uint32(magic[:]) == ebmlHeaderID:
+		return readMatroskaDates(file)
+	}
+
+	return nil, errUnsupportedFormat
+}
+
+// JPEG
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+func readJPEGDates(file *os.File) (map[string]string, error) {
+	if _, err := file.Seek(2, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(file)
+
+	dates := make(map[string]string)
+	for {
+		marker, err := nextJPEGMarker(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// Markers without a length field
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+		// Start of scan or end of image: no metadata follows
+		if marker == 0xDA || marker == 0xD9 {
+			return dates, nil
+		}
+
+		var lengthBytes [2]byte
+		if _, err := io.ReadFull(r, lengthBytes[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(lengthBytes[:]))
+		if length < 2 {
+			return nil, fmt.Errorf("invalid JPEG segment length %d", length)
+		}
+
+		if marker != 0xE1 {
+			if _, err := r.Discard(length - 2); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case bytes.HasPrefix(segment, exifHeader):
+			if ed, err := parseExif(segment[len(exifHeader):]); err == nil {
+				mergeDates(dates, ed.dates())
+			}
+		case bytes.HasPrefix(segment, xmpHeader):
+			mergeDates(dates, parseXMPDates(segment[len(xmpHeader):]))
+		}
+	}
+}
+
+func nextJPEGMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("invalid JPEG marker 0x%02X", b)
+	}
+	// Skip fill bytes
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// PNG
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func readPNGDates(file *os.File) (map[string]string, error) {
+	size, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+	pos := int64(len(pngSignature))
+	if _, err := file.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(file)
+
+	dates := make(map[string]string)
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return dates, nil
+			}
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		// A corrupt length must not size an allocation beyond the file
+		pos += 8 + length + 4
+		if pos > size {
+			return nil, fmt.Errorf("truncated PNG chunk %q (%d bytes)", chunkType, length)
+		}
+
+		switch chunkType {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			mergeDates(dates, pngChunkDates(chunkType, data))
+		case "IEND":
+			return dates, nil
+		default:
+			if _, err := r.Discard(int(length)); err != nil {
+				return nil, err
+			}
+		}
+
+		// Skip the CRC
+		if _, err := r.Discard(4); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func pngChunkDates(chunkType string, data []byte) map[string]string {
+	if chunkType == "eXIf" {
+		if ed, err := parseExif(data); err == nil {
+			return ed.dates()
+		}
+		return nil
+	}
+
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return nil
+	}
+
+	var text []byte
+	switch chunkType {
+	case "tEXt":
+		text = rest
+	case "zTXt":
+		if len(rest) < 1 {
+			return nil
+		}
+		text = inflate(rest[1:])
+	case "iTXt":
+		// compression flag, compression method, language tag, translated keyword
+		if len(rest) < 2 {
+			return nil
+		}
+		compressed := rest[0] == 1
+		parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+		if len(parts) != 3 {
+			return nil
+		}
+		text = parts[2]
+		if compressed {
+			text = inflate(text)
+		}
+	}
+
+	switch string(keyword) {
+	case "Creation Time":
+		if value := strings.TrimSpace(string(text)); value != "" {
+			return map[string]string{"CreationDate": value}
+		}
+	case "XML:com.adobe.xmp":
+		return parseXMPDates(text)
+	}
+	return nil
+}
+
+func inflate(data []byte) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+
+	out, _ := io.ReadAll(io.LimitReader(zr, 1<<20))
+	return out
+}
+
+// XMP
+
+var xmpDateTags = map[string]string{
+	"xmp:CreateDate":         "CreateDate",
+	"exif:DateTimeOriginal":  "DateTimeOriginal",
+	"exif:DateTimeDigitized": "DateTimeDigitized",
+}
+
+var xmpDatePattern = regexp.MustCompile(`(xmp:CreateDate|exif:DateTimeOriginal|exif:DateTimeDigitized)(?:\s*=\s*"([^"]*)"|>([^<]*)<)`)
+
+func parseXMPDates(packet []byte) map[string]string {
+	dates := make(map[string]string)
+	for _, m := range xmpDatePattern.FindAllSubmatch(packet, -1) {
+		value := strings.TrimSpace(string(m[2]) + string(m[3]))
+		if value != "" {
+			dates[xmpDateTags[string(m[1])]] = value
+		}
+	}
+	return dates
+}
+
+// ISO base media file format (HEIF, MP4, QuickTime)
+
+func isHEIFBrand(brand string) bool {
+	switch brand {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1", "avif":
+		return true
+	}
+	return false
+}
+
+func isQuickTimeAtom(atom string) bool {
+	switch atom {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+type isoBox struct {
+	boxType string
+	start   int64 // offset of the box payload
+	end     int64 // offset just past the box
+}
+
+// walkBoxes calls fn for every box between start and end
+func walkBoxes(r io.ReaderAt, start, end int64, fn func(box isoBox) error) error {
+	for pos := start; pos+8 <= end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			if _, err := r.ReadAt(header[8:16], pos+8); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || pos+size > end {
+			return fmt.Errorf("invalid box size %d at offset %d", size, pos)
+		}
+
+		box := isoBox{boxType: string(header[4:8]), start: pos + headerSize, end: pos + size}
+		if err := fn(box); err != nil {
+			return err
+		}
+		pos += size
+	}
+	return nil
+}
+
+func readBoxData(r io.ReaderAt, box isoBox, limit int64) ([]byte, error) {
+	size := box.end - box.start
+	if size > limit {
+		return nil, fmt.Errorf("box %q too large (%d bytes)", box.boxType, size)
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, box.start); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func fileSize(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// HEIF
+
+type heifItem struct {
+	itemType    string
+	contentType string
+	extents     [][2]uint64 // offset, length
+}
+
+func readHEIFDates(file *os.File) (map[string]string, error) {
+	size, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[uint32]*heifItem)
+	err = walkBoxes(file, 0, size, func(box isoBox) error {
+		if box.boxType != "meta" {
+			return nil
+		}
+		// meta is a full box: skip version and flags
+		return walkBoxes(file, box.start+4, box.end, func(child isoBox) error {
+			switch child.boxType {
+			case "iinf":
+				data, err := readBoxData(file, child, 1<<20)
+				if err != nil {
+					return err
+				}
+				return parseIINF(data, items)
+			case "iloc":
+				data, err := readBoxData(file, child, 1<<20)
+				if err != nil {
+					return err
+				}
+				return parseILOC(data, items)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]string)
+	for _, item := range items {
+		if len(item.extents) == 0 {
+			continue
+		}
+		isXMP := item.itemType == "mime" && item.contentType == "application/rdf+xml"
+		if item.itemType != "Exif" && !isXMP {
+			continue
+		}
+
+		var payload []byte
+		for _, extent := range item.extents {
+			if extent[1] > 16<<20 {
+				return nil, fmt.Errorf("HEIF metadata item too large")
+			}
+			chunk := make([]byte, extent[1])
+			if _, err := file.ReadAt(chunk, int64(extent[0])); err != nil {
+				return nil, err
+			}
+			payload = append(payload, chunk...)
+		}
+
+		if isXMP {
+			mergeDates(dates, parseXMPDates(payload))
+			continue
+		}
+
+		// The Exif item starts with the offset of the TIFF header
+		if len(payload) < 4 {
+			continue
+		}
+		offset := uint64(binary.BigEndian.Uint32(payload)) + 4
+		if offset >= uint64(len(payload)) {
+			continue
+		}
+		if ed, err := parseExif(payload[offset:]); err == nil {
+			mergeDates(dates, ed.dates())
+		}
+	}
+
+	return dates, nil
+}
+
+func parseIINF(data []byte, items map[uint32]*heifItem) error {
+	if len(data) < 6 {
+		return errInvalidBox("iinf")
+	}
+	start := int64(6)
+	if data[0] != 0 {
+		start = 8
+	}
+
+	r := bytes.NewReader(data)
+	return walkBoxes(r, start, int64(len(data)), func(box isoBox) error {
+		if box.boxType != "infe" {
+			return nil
+		}
+		infe := data[box.start:box.end]
+		if len(infe) < 4 || infe[0] < 2 {
+			return nil
+		}
+
+		var id uint32
+		pos := 4
+		if infe[0] == 2 {
+			if len(infe) < pos+8 {
+				return nil
+			}
+			id = uint32(binary.BigEndian.Uint16(infe[pos:]))
+			pos += 2
+		} else {
+			if len(infe) < pos+10 {
+				return nil
+			}
+			id = binary.BigEndian.Uint32(infe[pos:])
+			pos += 4
+		}
+		pos += 2 // item_protection_index
+
+		item := itemFor(items, id)
+		item.itemType = string(infe[pos : pos+4])
+		pos += 4
+
+		if item.itemType == "mime" {
+			// item_name and content_type are null terminated strings
+			fields := bytes.SplitN(infe[pos:], []byte{0}, 3)
+			if len(fields) >= 2 {
+				item.contentType = string(fields[1])
+			}
+		}
+		return nil
+	})
+}
+
+func parseILOC(data []byte, items map[uint32]*heifItem) error {
+	if len(data) < 8 {
+		return errInvalidBox("iloc")
+	}
+	version := data[0]
+	offsetSize := int(data[4] >> 4)
+	lengthSize := int(data[4] & 0x0F)
+	baseOffsetSize := int(data[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(data[5] & 0x0F)
+	}
+
+	pos := 6
+	readUint := func(size int) (uint64, bool) {
+		if pos+size > len(data) {
+			return 0, false
+		}
+		var v uint64
+		for i := 0; i < size; i++ {
+			v = v<<8 | uint64(data[pos+i])
+		}
+		pos += size
+		return v, true
+	}
+
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count, ok := readUint(idSize)
+	if !ok {
+		return errInvalidBox("iloc")
+	}
+
+	for i := uint64(0); i < count; i++ {
+		id, ok := readUint(idSize)
+		if !ok {
+			return errInvalidBox("iloc")
+		}
+
+		constructionMethod := uint64(0)
+		if version == 1 || version == 2 {
+			if constructionMethod, ok = readUint(2); !ok {
+				return errInvalidBox("iloc")
+			}
+			constructionMethod &= 0x0F
+		}
+		if _, ok := readUint(2); !ok { // data_reference_index
+			return errInvalidBox("iloc")
+		}
+		baseOffset, ok := readUint(baseOffsetSize)
+		if !ok {
+			return errInvalidBox("iloc")
+		}
+		extentCount, ok := readUint(2)
+		if !ok {
+			return errInvalidBox("iloc")
+		}
+
+		var extents [][2]uint64
+		for j := uint64(0); j < extentCount; j++ {
+			if _, ok := readUint(indexSize); !ok {
+				return errInvalidBox("iloc")
+			}
+			offset, ok1 := readUint(offsetSize)
+			length, ok2 := readUint(lengthSize)
+			if !ok1 || !ok2 {
+				return errInvalidBox("iloc")
+			}
+			extents = append(extents, [2]uint64{baseOffset + offset, length})
+		}
+
+		// Only items stored directly in the file are supported
+		if constructionMethod == 0 {
+			itemFor(items, uint32(id)).extents = extents
+		}
+	}
+	return nil
+}
+
+func itemFor(items map[uint32]*heifItem, id uint32) *heifItem {
+	item, ok := items[id]
+	if !ok {
+		item = &heifItem{}
+		items[id] = item
+	}
+	return item
+}
+
+func errInvalidBox(boxType string) error {
+	return fmt.Errorf("invalid %s box", boxType)
+}
+
+// QuickTime / MP4
+
+// quickTimeEpoch is the zero point of QuickTime timestamps
+var quickTimeEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func readQuickTimeDates(file *os.File) (map[string]string, error) {
+	size, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]string)
+	err = walkBoxes(file, 0, size, func(box isoBox) error {
+		if box.boxType != "moov" {
+			return nil
+		}
+		return readMoovDates(file, box, dates)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dates, nil
+}
+
+func readMoovDates(file *os.File, moov isoBox, dates map[string]string) error {
+	var keys []string
+	var ilst isoBox
+
+	err := walkBoxes(file, moov.start, moov.end, func(box isoBox) error {
+		switch box.boxType {
+		case "mvhd":
+			return setQuickTimeDate(file, box, "CreateDate", dates)
+		case "trak":
+			// Only the first track's dates are reported, like exiftool does
+			if _, ok := dates["TrackCreateDate"]; ok {
+				return nil
+			}
+			return walkBoxes(file, box.start, box.end, func(child isoBox) error {
+				switch child.boxType {
+				case "tkhd":
+					return setQuickTimeDate(file, child, "TrackCreateDate", dates)
+				case "mdia":
+					return walkBoxes(file, child.start, child.end, func(mdia isoBox) error {
+						if mdia.boxType == "mdhd" {
+							return setQuickTimeDate(file, mdia, "MediaCreateDate", dates)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		case "udta":
+			return walkBoxes(file, box.start, box.end, func(child isoBox) error {
+				if child.boxType != "\xa9day" {
+					return nil
+				}
+				data, err := readBoxData(file, child, 1<<16)
+				if err != nil {
+					return err
+				}
+				// QuickTime user data text: 16-bit length and language code
+				if len(data) > 4 {
+					if value := strings.TrimSpace(string(data[4:])); value != "" {
+						dates["ContentCreateDate"] = value
+					}
+				}
+				return nil
+			})
+		case "meta":
+			return walkBoxes(file, box.start, box.end, func(child isoBox) error {
+				switch child.boxType {
+				case "keys":
+					data, err := readBoxData(file, child, 1<<20)
+					if err != nil {
+						return err
+					}
+					keys = parseQuickTimeKeys(data)
+				case "ilst":
+					ilst = child
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if ilst.boxType != "" {
+		if value := quickTimeKeyValue(file, ilst, keys, "com.apple.quicktime.creationdate"); value != "" {
+			dates["CreationDate"] = value
+		}
+	}
+	return nil
+}
+
+func setQuickTimeDate(file *os.File, box isoBox, name string, dates map[string]string) error {
+	data, err := readBoxData(file, box, 1<<16)
+	if err != nil {
+		return err
+	}
+	if len(data) < 8 {
+		return errInvalidBox(box.boxType)
+	}
+
+	var seconds uint64
+	if data[0] == 1 {
+		if len(data) < 12 {
+			return errInvalidBox(box.boxType)
+		}
+		seconds = binary.BigEndian.Uint64(data[4:])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(data[4:]))
+	}
+
+	// A zero timestamp means the date was never set
+	if seconds != 0 {
+		t := quickTimeEpoch.Add(time.Duration(seconds) * time.Second)
+		dates[name] = t.Format("2006:01:02 15:04:05")
+	}
+	return nil
+}
+
+func parseQuickTimeKeys(data []byte) []string {
+	if len(data) < 8 {
+		return nil
+	}
+	count := binary.BigEndian.Uint32(data[4:])
+	var keys []string
+	pos := 8
+	for i := uint32(0); i < count && pos+8 <= len(data); i++ {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		if size < 8 || pos+size > len(data) {
+			break
+		}
+		keys = append(keys, string(data[pos+8:pos+size]))
+		pos += size
+	}
+	return keys
+}
+
+func quickTimeKeyValue(file *os.File, ilst isoBox, keys []string, key string) string {
+	var value string
+	walkBoxes(file, ilst.start, ilst.end, func(entry isoBox) error {
+		// ilst entries are named by their 1-based index into keys
+		index := int(binary.BigEndian.Uint32([]byte(entry.boxType)))
+		if index < 1 || index > len(keys) || keys[index-1] != key {
+			return nil
+		}
+		return walkBoxes(file, entry.start, entry.end, func(data isoBox) error {
+			if data.boxType != "data" {
+				return nil
+			}
+			payload, err := readBoxData(file, data, 1<<16)
+			if err != nil {
+				return err
+			}
+			// type indicator and locale precede the value
+			if len(payload) > 8 {
+				value = strings.TrimSpace(string(payload[8:]))
+			}
+			return nil
+		})
+	})
+	return value
+}
+
+func mergeDates(dst, src map[string]string) {
+	for name, value := range src {
+		if _, ok := dst[name]; !ok {
+			dst[name] = value
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// buildTestTIFF builds a little-endian TIFF block with a DateTimeOriginal tag
+func buildTestTIFF(dateTimeOriginal string) []byte {
+	value := append([]byte(dateTimeOriginal), 0)
+	le := binary.LittleEndian
+
+	var b bytes.Buffer
+	b.WriteString("II")
+	binary.Write(&b, le, uint16(42))
+	binary.Write(&b, le, uint32(8))
+
+	// IFD0 with a single pointer to the EXIF IFD at offset 26
+	binary.Write(&b, le, uint16(1))
+	binary.Write(&b, le, []uint16{tagExifIFD, tiffLong})
+	binary.Write(&b, le, []uint32{1, 26})
+	binary.Write(&b, le, uint32(0))
+
+	// EXIF IFD with DateTimeOriginal stored after the IFD at offset 44
+	binary.Write(&b, le, uint16(1))
+	binary.Write(&b, le, []uint16{tagDateTimeOriginal, tiffASCII})
+	binary.Write(&b, le, []uint32{uint32(len(value)), 44})
+	binary.Write(&b, le, uint32(0))
+	b.Write(value)
+
+	return b.Bytes()
+}
+
+func buildTestJPEG(tiff []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xD8})
+	if tiff != nil {
+		segment := append(append([]byte(nil), exifHeader...), tiff...)
+		b.Write([]byte{0xFF, 0xE1})
+		binary.Write(&b, binary.BigEndian, uint16(len(segment)+2))
+		b.Write(segment)
+	}
+	b.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0x00, 0xFF, 0xD9})
+	return b.Bytes()
+}
+
+func writePNGChunk(b *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(chunkType)
+	b.Write(data)
+	binary.Write(b, binary.BigEndian, uint32(0)) // CRC is not verified
+}
+
+func box(boxType string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)+8))
+	copy(header[4:], boxType)
+	return append(header, data...)
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	return path
+}
+
+func TestNativeReader_JPEG(t *testing.T) {
+	dates, err := nativeReader{}.ReadDates("test/20170608_194241.jpg")
+	if err != nil {
+		t.Fatalf("ReadDates() error = %v", err)
+	}
+	if dates["DateTimeOriginal"] != "2017:06:08 19:42:41" {
+		t.Errorf("DateTimeOriginal = %q, want 2017:06:08 19:42:41", dates["DateTimeOriginal"])
+	}
+	if dates["GPSDateStamp"] != "2017:06:08" {
+		t.Errorf("GPSDateStamp = %q, want 2017:06:08", dates["GPSDateStamp"])
+	}
+
+	// JPEG without any metadata
+	path := writeTestFile(t, "bare.jpg", buildTestJPEG(nil))
+	dates, err = nativeReader{}.ReadDates(path)
+	if err != nil {
+		t.Fatalf("ReadDates() error = %v", err)
+	}
+	if len(dates) != 0 {
+		t.Errorf("ReadDates() on bare JPEG = %v, want no dates", dates)
+	}
+}
+
+func TestNativeReader_JPEGXMP(t *testing.T) {
+	packet := []byte(`<x:xmpmeta><rdf:Description xmp:CreateDate="2019-04-01T10:00:00+09:00"/></x:xmpmeta>`)
+	segment := append(append([]byte(nil), xmpHeader...), packet...)
+
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&b, binary.BigEndian, uint16(len(segment)+2))
+	b.Write(segment)
+	b.Write([]byte{0xFF, 0xD9})
+
+	dates, err := nativeReader{}.ReadDates(writeTestFile(t, "xmp.jpg", b.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadDates() error = %v", err)
+	}
+	if dates["CreateDate"] != "2019-04-01T10:00:00+09:00" {
+		t.Errorf("CreateDate = %q, want 2019-04-01T10:00:00+09:00", dates["CreateDate"])
+	}
+}
+
+func TestNativeReader_PNG(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("2020:01:02 03:04:05"))
+	zw.Close()
+
+	tests := []struct {
+		name  string
+		chunk string
+		data  []byte
+		tag   string
+		want  string
+	}{
+		{"eXIf", "eXIf", buildTestTIFF("2021:05:06 07:08:09"), "DateTimeOriginal", "2021:05:06 07:08:09"},
+		{"tEXt", "tEXt", []byte("Creation Time\x002018:02:03 04:05:06"), "CreationDate", "2018:02:03 04:05:06"},
+		{"zTXt", "zTXt", append([]byte("Creation Time\x00\x00"), compressed.Bytes()...), "CreationDate", "2020:01:02 03:04:05"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			b.Write(pngSignature)
+			writePNGChunk(&b, "IHDR", make([]byte, 13))
+			writePNGChunk(&b, tt.chunk, tt.data)
+			writePNGChunk(&b, "IDAT", []byte{1, 2, 3})
+			writePNGChunk(&b, "IEND", nil)
+
+			dates, err := nativeReader{}.ReadDates(writeTestFile(t, "image.png", b.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadDates() error = %v", err)
+			}
+			if dates[tt.tag] != tt.want {
+				t.Errorf("%s = %q, want %q", tt.tag, dates[tt.tag], tt.want)
+			}
+		})
+	}
+}
+
+func TestNativeReader_PNGCorruptLength(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(pngSignature)
+	writePNGChunk(&b, "IHDR", make([]byte, 13))
+	// A tEXt chunk claiming nearly 4 GB in a file of a few bytes
+	b.Write([]byte{0xFF, 0xFF, 0xFF, 0xF0})
+	b.WriteString("tEXt")
+	b.WriteString("Creation Time")
+
+	if _, err := (nativeReader{}).ReadDates(writeTestFile(t, "corrupt.png", b.Bytes())); err == nil {
+		t.Error("ReadDates() error = nil, want a truncated chunk error")
+	}
+}
+
+func TestNativeReader_QuickTime(t *testing.T) {
+	created := time.Date(2017, 6, 8, 23, 42, 41, 0, time.UTC)
+	seconds := uint32(created.Sub(quickTimeEpoch) / time.Second)
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:], seconds)
+	tkhd := make([]byte, 84) // zero creation time must be ignored
+
+	day := []byte{0, 10, 0x15, 0xC7}
+	day = append(day, "2017-06-08"...)
+
+	data := append(box("ftyp", []byte("qt  \x00\x00\x00\x00")),
+		box("moov",
+			box("mvhd", mvhd),
+			box("trak", box("tkhd", tkhd)),
+			box("udta", box("\xa9day", day)),
+		)...)
+	data = append(data, box("mdat", []byte{0, 0, 0, 0})...)
+
+	dates, err := nativeReader{}.ReadDates(writeTestFile(t, "video.mov", data))
+	if err != nil {
+		t.Fatalf("ReadDates() error = %v", err)
+	}
+	if dates["CreateDate"] != "2017:06:08 23:42:41" {
+		t.Errorf("CreateDate = %q, want 2017:06:08 23:42:41", dates["CreateDate"])
+	}
+	if _, ok := dates["TrackCreateDate"]; ok {
+		t.Errorf("TrackCreateDate = %q, want unset for zero timestamp", dates["TrackCreateDate"])
+	}
+	if dates["ContentCreateDate"] != "2017-06-08" {
+		t.Errorf("ContentCreateDate = %q, want 2017-06-08", dates["ContentCreateDate"])
+	}
+}
+
+func TestNativeReader_HEIF(t *testing.T) {
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+
+	// Exif item payload: 4-byte TIFF header offset, "Exif\0\0", TIFF data
+	exifPayload := append([]byte{0, 0, 0, 6}, exifHeader...)
+	exifPayload = append(exifPayload, buildTestTIFF("2022:07:08 09:10:11")...)
+
+	infe := append([]byte{2, 0, 0, 0, 0, 1, 0, 0}, "Exif"...)
+	infe = append(infe, 0)
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 1}, box("infe", infe))
+
+	buildMeta := func(offset uint32) []byte {
+		iloc := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 1, 0, 1, 0, 0, 0, 1}
+		iloc = binary.BigEndian.AppendUint32(iloc, offset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(exifPayload)))
+		return box("meta", []byte{0, 0, 0, 0}, iinf, box("iloc", iloc))
+	}
+
+	// The item offset depends on the size of the boxes in front of mdat
+	offset := uint32(len(ftyp) + len(buildMeta(0)) + 8)
+	data := append(append(ftyp, buildMeta(offset)...), box("mdat", exifPayload)...)
+
+	dates, err := nativeReader{}.ReadDates(writeTestFile(t, "image.heic", data))
+	if err != nil {
+		t.Fatalf("ReadDates() error = %v", err)
+	}
+	if dates["DateTimeOriginal"] != "2022:07:08 09:10:11" {
+		t.Errorf("DateTimeOriginal = %q, want 2022:07:08 09:10:11", dates["DateTimeOriginal"])
+	}
+}
+
+func TestNativeReader_Unsupported(t *testing.T) {
+	path := writeTestFile(t, "image.gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"))
+	if _, err := (nativeReader{}).ReadDates(path); err != errUnsupportedFormat {
+		t.Errorf("ReadDates() error = %v, want errUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// illegalNameChars are the characters Windows and most zip tools cannot
+// store in a file name and replace with "_" on extraction
+const illegalNameChars = `<>:"/\|?*`
+
+// normalizeName returns the key two spellings of a file name share: both
+// are decomposed (NFD) so NFC titles meet the NFD names macOS writes,
+// case-folded, and characters other systems cannot store become "_".
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < 0x20 || strings.ContainsRune(illegalNameChars, r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return norm.NFD.String(b.String())
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"NFC and NFD", "Café.jpg", "Cafe\u0301.jpg", true},
+		{"angstrom sign", "\u212bngström.jpg", "A\u030angstro\u0308m.jpg", true},
+		{"Hangul", "사진.jpg", "\u1109\u1161\u110c\u1175\u11ab.jpg", true},
+		{"kana voiced mark", "がっこう.jpg", "か\u3099っこう.jpg", true},
+		{"Vietnamese stacked marks", "Việt.jpg", "Vie\u0323\u0302t.jpg", true},
+		{"marks in another order", "Vie\u0302\u0323t.jpg", "Vie\u0323\u0302t.jpg", true},
+		{"CJK compatibility ideograph", "\uf900.jpg", "\u8c48.jpg", true},
+		{"case", "IMG_1234.JPG", "img_1234.jpg", true},
+		{"replaced characters", `Party: "2019"?.jpg`, "Party_ _2019__.jpg", true},
+		{"different accent", "Café.jpg", "Cafè.jpg", false},
+		{"accent removed", "Café.jpg", "Cafe.jpg", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := normalizeName(tt.a), normalizeName(tt.b)
+			if (a == b) != tt.equal {
+				t.Errorf("normalizeName(%q) = %q, normalizeName(%q) = %q, want equal = %v", tt.a, a, tt.b, b, tt.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the sort log
+const (
+	opMkdir          = "mkdir"
+	opMove           = "move"
+	opCopy           = "copy"
+	opSymlink        = "symlink"
+	opReplaceSymlink = "replace-symlink"
+)
+
+// sortOperation is one change made to the filesystem by a sort run
+type sortOperation struct {
+	Op       string    `json:"op"`
+	Source   string    `json:"source,omitempty"`   // original location of a moved or copied file
+	Dest     string    `json:"dest"`               // file, directory or symlink created
+	Target   string    `json:"target,omitempty"`   // target of a created symlink
+	Previous string    `json:"previous,omitempty"` // target of the symlink that was replaced
+	Time     time.Time `json:"time"`
+}
+
+// operationLog is an append-only JSON lines record of a sort run that
+// -undo replays backwards. A nil log records nothing.
+type operationLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func createOperationLog(path string) (*operationLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &operationLog{file: file}, nil
+}
+
+// record appends an operation with absolute paths so the log can be undone
+// from any working directory
+func (l *operationLog) record(op sortOperation) {
+	if l == nil {
+		return
+	}
+	for _, path := range []*string{&op.Source, &op.Dest} {
+		if *path != "" {
+			if abs, err := filepath.Abs(*path); err == nil {
+				*path = abs
+			}
+		}
+	}
+	op.Time = time.Now()
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Warning: Could not write operation log: %v", err)
+	}
+}
+
+// ensureDirectory creates a directory and its missing parents, recording
+// each directory it creates
+func (l *operationLog) ensureDirectory(path string, dryRun bool) error {
+	if l == nil || dryRun {
+		return ensureDirectory(path, dryRun)
+	}
+
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		l.record(sortOperation{Op: opMkdir, Dest: missing[i]})
+	}
+	return nil
+}
+
+func (l *operationLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func readOperationLog(path string) ([]sortOperation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ops []sortOperation
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var op sortOperation
+		// A run killed mid-write can leave a partial last line
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops, scanner.Err()
+}
+
+// performUndo reverses a sort run from its operation log: moved files go
+// back to their Takeout location, copies and symlinks are removed, replaced
+// symlinks are restored and created directories are removed when empty
+func performUndo(logPath string, dryRun bool) {
+	fmt.Printf("UNDO MODE: Reversing sort run from %s...\n", logPath)
+
+	ops, err := readOperationLog(logPath)
+	if err != nil {
+		log.Fatalf("Error reading operation log: %v", err)
+	}
+	fmt.Printf("Found %d operations to reverse\n", len(ops))
+
+	var undone, failed int
+	for i := len(ops) - 1; i >= 0; i-- {
+		if err := undoOperation(ops[i], dryRun); err != nil {
+			log.Printf("Could not undo %s of %s: %v", ops[i].Op, ops[i].Dest, err)
+			failed++
+			continue
+		}
+		undone++
+	}
+
+	fmt.Printf("Undo complete! Reversed %d operations, %d could not be reversed.\n", undone, failed)
+}
+
+func undoOperation(op sortOperation, dryRun bool) error {
+	switch op.Op {
+	case opMove:
+		if _, err := os.Lstat(op.Source); err == nil {
+			return fmt.Errorf("%s already exists", op.Source)
+		}
+		if dryRun {
+			log.Printf("[DRY RUN] Would move file back: %s -> %s", op.Dest, op.Source)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(op.Source), 0755); err != nil {
+			return err
+		}
+		return os.Rename(op.Dest, op.Source)
+
+	case opCopy:
+		if dryRun {
+			log.Printf("[DRY RUN] Would remove copied file: %s", op.Dest)
+			return nil
+		}
+		return os.Remove(op.Dest)
+
+	case opSymlink, opReplaceSymlink:
+		// Leave links alone that were changed after the sort run
+		if target, err := os.Readlink(op.Dest); err != nil || target != op.Target {
+			return errors.New("symlink was changed after the sort run")
+		}
+		if dryRun {
+			log.Printf("[DRY RUN] Would remove symlink: %s", op.Dest)
+			if op.Op == opReplaceSymlink {
+				log.Printf("[DRY RUN] Would restore symlink: %s -> %s", op.Dest, op.Previous)
+			}
+			return nil
+		}
+		if err := os.Remove(op.Dest); err != nil {
+			return err
+		}
+		if op.Op == opReplaceSymlink {
+			return os.Symlink(op.Previous, op.Dest)
+		}
+		return nil
+
+	case opMkdir:
+		if entries, err := os.ReadDir(op.Dest); err != nil || len(entries) > 0 {
+			// Directories that are gone or still in use are left as they are
+			return nil
+		}
+		if dryRun {
+			log.Printf("[DRY RUN] Would remove empty directory: %s", op.Dest)
+			return nil
+		}
+		return os.Remove(op.Dest)
+	}
+	return fmt.Errorf("unknown operation %q", op.Op)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSortUndo(t *testing.T) {
+	sourceDir := filepath.Join(t.TempDir(), "Summer")
+	destDir := filepath.Join(t.TempDir(), "sorted")
+	imagePath := filepath.Join(sourceDir, "20170608_194241.jpg")
+	editedPath := filepath.Join(sourceDir, "20170608_194241-edited.jpg")
+	videoPath := filepath.Join(sourceDir, "20170608_194241.mp4")
+	jsonPath := imagePath + ".supplemental-metadata.json"
+
+	sidecar, err := os.ReadFile("test/20170608_194241.jpg.supplemental-metadata.json")
+	if err != nil {
+		t.Fatalf("Failed to read sidecar: %v", err)
+	}
+	files := map[string]string{
+		imagePath:  "image",
+		editedPath: "edited",
+		videoPath:  "video",
+		jsonPath:   string(sidecar),
+		filepath.Join(sourceDir, "metadata.json"): `{"title": "Summer"}`,
+	}
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	logPath := filepath.Join(t.TempDir(), "sort_operations.jsonl")
+	oplog, err := createOperationLog(logPath)
+	if err != nil {
+		t.Fatalf("createOperationLog() error = %v", err)
+	}
+	if err := oplog.ensureDirectory(destDir, false); err != nil {
+		t.Fatalf("ensureDirectory() error = %v", err)
+	}
+	if _, err := sortFile(1, jsonPath, destDir, false, false, newMediaMatcher(defaultEditedSuffixes), nil, oplog); err != nil {
+		t.Fatalf("sortFile() error = %v", err)
+	}
+	oplog.Close()
+
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Fatal("sortFile() did not move the image")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "Summer", "20170608_194241.jpg")); err != nil {
+		t.Fatalf("sortFile() did not link the image into its album: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "Summer", "20170608_194241-edited.jpg")); err != nil || string(data) != "edited" {
+		t.Fatalf("sortFile() did not sort the edited copy with the original: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "2017", "06", "08", "20170608_194241.mp4")); err != nil || string(data) != "video" {
+		t.Fatalf("sortFile() did not sort the Live Photo video with the photo: %v", err)
+	}
+
+	// A dry run changes nothing
+	performUndo(logPath, true)
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Fatal("performUndo() dry run moved the image back")
+	}
+
+	performUndo(logPath, false)
+	if data, err := os.ReadFile(imagePath); err != nil || string(data) != "image" {
+		t.Errorf("performUndo() did not restore the image: %v", err)
+	}
+	if data, err := os.ReadFile(editedPath); err != nil || string(data) != "edited" {
+		t.Errorf("performUndo() did not restore the edited copy: %v", err)
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Errorf("performUndo() left the created destination behind: %v", err)
+	}
+}
+
+func TestUndoOperation_ReplacedSymlink(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "photo.jpg")
+	if err := os.Symlink("../2017/06/08/photo.jpg", link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	op := sortOperation{Op: opReplaceSymlink, Dest: link, Target: "../2017/06/08/photo.jpg", Previous: "../2016/01/01/photo.jpg"}
+	if err := undoOperation(op, false); err != nil {
+		t.Fatalf("undoOperation() error = %v", err)
+	}
+	if target, _ := os.Readlink(link); target != op.Previous {
+		t.Errorf("symlink target = %q, want %q", target, op.Previous)
+	}
+
+	// A link changed since the run is not touched
+	op.Target = "somewhere/else.jpg"
+	if err := undoOperation(op, false); err == nil {
+		t.Error("undoOperation() on a changed symlink: error = nil")
+	}
+}
+
+func TestUndoOperation_NonEmptyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "keep.jpg"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := undoOperation(sortOperation{Op: opMkdir, Dest: dir}, false); err != nil {
+		t.Errorf("undoOperation() error = %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Error("undoOperation() removed a directory that is still in use")
+	}
+}
+
+func TestSortFile_AlbumSidecarOnly(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := filepath.Join(t.TempDir(), "sorted")
+	sidecar, err := os.ReadFile("test/20170608_194241.jpg.supplemental-metadata.json")
+	if err != nil {
+		t.Fatalf("Failed to read sidecar: %v", err)
+	}
+
+	// The album folder only holds the sidecar, the photo is in its year folder
+	yearDir := filepath.Join(sourceDir, "Photos from 2017")
+	albumDir := filepath.Join(sourceDir, "Summer")
+	files := map[string]string{
+		filepath.Join(yearDir, "20170608_194241.jpg"):                             "image",
+		filepath.Join(yearDir, "20170608_194241.jpg.supplemental-metadata.json"):  string(sidecar),
+		filepath.Join(albumDir, "20170608_194241.jpg.supplemental-metadata.json"): string(sidecar),
+		filepath.Join(albumDir, "metadata.json"):                                  `{"title": "Summer"}`,
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	matcher := newMediaMatcher(nil)
+	if err := matcher.indexTree(sourceDir); err != nil {
+		t.Fatalf("indexTree() error = %v", err)
+	}
+
+	for _, dir := range []string{albumDir, yearDir} {
+		jsonPath := filepath.Join(dir, "20170608_194241.jpg.supplemental-metadata.json")
+		if _, err := sortFile(1, jsonPath, destDir, false, false, matcher, nil, nil); err != nil {
+			t.Fatalf("sortFile(%s) error = %v", jsonPath, err)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(destDir, "Summer", "20170608_194241.jpg")); err != nil || string(data) != "image" {
+		t.Errorf("sortFile() did not link the year folder's photo into the album: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(yearDir, "20170608_194241.jpg")); !os.IsNotExist(err) {
+		t.Error("sortFile() did not move the photo out of its year folder")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes recorded in the update report
+const (
+	statusUpdated     = "updated"
+	statusWouldUpdate = "would-update"
+	statusSkipped     = "skipped"
+	statusFailed      = "failed"
+)
+
+// reportEntry is the outcome of a single JSON sidecar
+type reportEntry struct {
+	JSONFile  string        `json:"json_file"`
+	MediaFile string        `json:"media_file,omitempty"`
+	Match     string        `json:"match,omitempty"` // strategy that found the media file
+	Status    string        `json:"status"`
+	Detail    string        `json:"detail,omitempty"`
+	Messages  []fileMessage `json:"messages,omitempty"`
+}
+
+// updateReport collects the outcome of every sidecar processed in a run
+type updateReport struct {
+	mu      sync.Mutex
+	entries []reportEntry
+}
+
+func (r *updateReport) add(entry reportEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// counts returns how many entries have each status and how many carry
+// warnings or errors from the backend
+func (r *updateReport) counts() (map[string]int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make(map[string]int)
+	withMessages := 0
+	for _, e := range r.entries {
+		statuses[e.Status]++
+		if len(e.Messages) > 0 {
+			withMessages++
+		}
+	}
+	return statuses, withMessages
+}
+
+// write saves the report as an indented JSON document
+func (r *updateReport) write(path, sourceDir string, started time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(struct {
+		SourceDirectory string        `json:"source_directory"`
+		Started         time.Time     `json:"started"`
+		Finished        time.Time     `json:"finished"`
+		Files           []reportEntry `json:"files"`
+	}{sourceDir, started, time.Now(), r.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateReport(t *testing.T) {
+	report := &updateReport{}
+	report.add(reportEntry{JSONFile: "a.json", MediaFile: "a.jpg", Status: statusUpdated})
+	report.add(reportEntry{
+		JSONFile:  "b.json",
+		MediaFile: "b.jpg",
+		Status:    statusFailed,
+		Detail:    "Not a valid JPG (looks more like a PNG)",
+		Messages:  []fileMessage{{Level: "error", Message: "Not a valid JPG (looks more like a PNG)"}},
+	})
+	report.add(reportEntry{JSONFile: "c.json", Status: statusSkipped, Detail: "media file not found"})
+
+	statuses, withMessages := report.counts()
+	if statuses[statusUpdated] != 1 || statuses[statusFailed] != 1 || statuses[statusSkipped] != 1 {
+		t.Errorf("counts() statuses = %v, want one of each", statuses)
+	}
+	if withMessages != 1 {
+		t.Errorf("counts() withMessages = %d, want 1", withMessages)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.write(path, "/photos", time.Now()); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+	var decoded struct {
+		SourceDirectory string        `json:"source_directory"`
+		Files           []reportEntry `json:"files"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Report is not valid JSON: %v", err)
+	}
+	if decoded.SourceDirectory != "/photos" || len(decoded.Files) != 3 {
+		t.Errorf("report = %+v, want 3 files from /photos", decoded)
+	}
+	if decoded.Files[1].Messages[0].Message != "Not a valid JPG (looks more like a PNG)" {
+		t.Errorf("report messages = %+v, want the exiftool error", decoded.Files[1].Messages)
+	}
+}
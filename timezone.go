@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	// Zones are resolved without relying on the system's zoneinfo
+	_ "time/tzdata"
+)
+
+var (
+	zoneMu    sync.Mutex
+	zoneCache = make(map[string]*time.Location)
+)
+
+// loadZone returns the named zone, loading each one once
+func loadZone(name string) (*time.Location, error) {
+	zoneMu.Lock()
+	defer zoneMu.Unlock()
+	if loc, ok := zoneCache[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zoneCache[name] = loc
+	return loc, nil
+}
+
+// zoneName returns the IANA zone of a point. Points outside every zone's
+// boundaries, at sea, get the nautical zone for their longitude.
+func zoneName(lat, lon float64) string {
+	if zone := polygonZone(lat, lon); zone != "" {
+		return zone
+	}
+
+	// Etc zones count the other way: Etc/GMT-9 is nine hours ahead of UTC
+	hours := int(math.Round(lon / 15))
+	hours = max(-12, min(12, hours))
+	switch {
+	case hours > 0:
+		return fmt.Sprintf("Etc/GMT-%d", hours)
+	case hours < 0:
+		return fmt.Sprintf("Etc/GMT+%d", -hours)
+	}
+	return "Etc/GMT"
+}
+
+// location returns the coordinates the photo was taken at, preferring the
+// ones read from the file's EXIF data
+func (meta photoMetadata) location() geoData {
+	if meta.GeoDataExif.Latitude != 0 || meta.GeoDataExif.Longitude != 0 {
+		return meta.GeoDataExif
+	}
+	return meta.GeoData
+}
+
+// photoLocation returns the timezone a photo was taken in, resolved from its
+// coordinates. Photos without coordinates, or whose zone cannot be loaded,
+// use fallback.
+func photoLocation(gps geoData, fallback *time.Location) *time.Location {
+	if gps.Latitude == 0 && gps.Longitude == 0 {
+		return fallback
+	}
+	loc, err := loadZone(zoneName(gps.Latitude, gps.Longitude))
+	if err != nil {
+		return fallback
+	}
+	return loc
+}
+
+// ruleDates matches the date, or the inclusive range of dates, a rule
+// applies to
+var ruleDates = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(?:\.\.(\d{4}-\d{2}-\d{2}))?$`)
+
+// zoneRule assigns a timezone to the photos of a folder or of a span of
+// days
+type zoneRule struct {
+	folder   string // slash-separated, relative to the source directory
+	from, to string // YYYY-MM-DD, for date rules
+	loc      *time.Location
+}
+
+// matches reports whether the rule covers a photo whose sidecar lies in
+// relDir and that was taken at t
+func (r zoneRule) matches(relDir string, t time.Time) bool {
+	if r.folder == "" {
+		day := t.In(r.loc).Format("2006-01-02")
+		return day >= r.from && day <= r.to
+	}
+	if relDir == r.folder || strings.HasPrefix(relDir, r.folder+"/") {
+		return true
+	}
+	// A bare folder name matches that folder at any depth
+	return !strings.Contains(r.folder, "/") && slices.Contains(strings.Split(relDir, "/"), r.folder)
+}
+
+// zoneRules picks the timezone of photos without coordinates, from the
+// rules file and then the -timezone zone. A nil zoneRules leaves every
+// mode's own default in place.
+type zoneRules struct {
+	sourceDir   string
+	rules       []zoneRule
+	defaultZone *time.Location
+}
+
+// newZoneRules loads the -timezone zone and the rules file. It returns nil
+// when neither is given.
+func newZoneRules(sourceDir, defaultZone, rulesPath string) (*zoneRules, error) {
+	if defaultZone == "" && rulesPath == "" {
+		return nil, nil
+	}
+
+	z := &zoneRules{sourceDir: sourceDir}
+	if defaultZone != "" {
+		loc, err := time.LoadLocation(defaultZone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", defaultZone)
+		}
+		z.defaultZone = loc
+	}
+	if rulesPath != "" {
+		rules, err := readZoneRules(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		z.rules = rules
+	}
+	return z, nil
+}
+
+// readZoneRules parses a rules file. Each line maps a folder or dates to a
+// zone and the first line covering a photo wins. Blank lines and lines
+// starting with # are ignored:
+//
+//	Trip Japan 2019 = Asia/Tokyo
+//	2019-04-01..2019-04-14 = Asia/Tokyo
+//	2020-01-05 = America/New_York
+func readZoneRules(path string) ([]zoneRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []zoneRule
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Zone names never contain "=", folder names might
+		i := strings.LastIndex(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%s line %d: expected <folder or dates> = <timezone>", path, lineNum)
+		}
+		target, zone := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if target == "" {
+			return nil, fmt.Errorf("%s line %d: missing folder or dates", path, lineNum)
+		}
+		loc, err := time.LoadLocation(zone)
+		if zone == "" || err != nil {
+			return nil, fmt.Errorf("%s line %d: unknown timezone %q", path, lineNum, zone)
+		}
+
+		rule := zoneRule{loc: loc}
+		if m := ruleDates.FindStringSubmatch(target); m != nil {
+			rule.from, rule.to = m[1], m[2]
+			if rule.to == "" {
+				rule.to = rule.from
+			}
+			for _, day := range []string{rule.from, rule.to} {
+				if _, err := time.Parse("2006-01-02", day); err != nil {
+					return nil, fmt.Errorf("%s line %d: invalid date %q", path, lineNum, day)
+				}
+			}
+			if rule.to < rule.from {
+				return nil, fmt.Errorf("%s line %d: range ends before it starts", path, lineNum)
+			}
+		} else {
+			rule.folder = strings.Trim(filepath.ToSlash(filepath.Clean(target)), "/")
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// fallback returns the timezone for a photo without coordinates: the first
+// rule covering it, the -timezone zone, or else def
+func (z *zoneRules) fallback(jsonPath string, timestamp int64, def *time.Location) *time.Location {
+	if z == nil {
+		return def
+	}
+
+	relDir := "."
+	if rel, err := filepath.Rel(z.sourceDir, filepath.Dir(jsonPath)); err == nil {
+		relDir = filepath.ToSlash(rel)
+	}
+	t := time.Unix(timestamp, 0)
+	for _, r := range z.rules {
+		if r.matches(relDir, t) {
+			return r.loc
+		}
+	}
+	if z.defaultZone != nil {
+		return z.defaultZone
+	}
+	return def
+}
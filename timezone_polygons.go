@@ -0,0 +1,190 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+//go:generate go -C tools/tzgen run . -o ../../timezone_polygons.bin
+
+// timezonePolygons holds the boundaries of the IANA zones on land and in
+// territorial waters, simplified from timezone-boundary-builder (ODbL) by
+// tools/tzgen, which documents the format
+//
+//go:embed timezone_polygons.bin
+var timezonePolygons []byte
+
+// polygonScale is the number of coordinate units per degree
+const polygonScale = 1e4
+
+// nearbyZoneDistance is how far, in degrees, a point outside every polygon
+// may be from one to still get its zone. Simplifying neighbouring zones
+// separately leaves slivers along their borders that belong to neither.
+const nearbyZoneDistance = 0.02
+
+// zonePolygon is an area of one IANA zone: its outline and holes as
+// longitude and latitude pairs, in polygonScale units
+type zonePolygon struct {
+	zone                           string
+	minLon, minLat, maxLon, maxLat float64
+	rings                          [][]int32
+}
+
+// contains reports whether a point lies inside the outline and outside
+// the holes
+func (p *zonePolygon) contains(lon, lat float64) bool {
+	if lon < p.minLon || lon > p.maxLon || lat < p.minLat || lat > p.maxLat {
+		return false
+	}
+	inside := false
+	for _, ring := range p.rings {
+		for i, j := 0, len(ring)-2; i < len(ring); j, i = i, i+2 {
+			xi, yi := float64(ring[i]), float64(ring[i+1])
+			xj, yj := float64(ring[j]), float64(ring[j+1])
+			if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// distance returns how far a point is from the rings, in polygonScale
+// units, or +Inf when it is farther than limit from the bounding box
+func (p *zonePolygon) distance(lon, lat, limit float64) float64 {
+	if lon < p.minLon-limit || lon > p.maxLon+limit || lat < p.minLat-limit || lat > p.maxLat+limit {
+		return math.Inf(1)
+	}
+	nearest := math.Inf(1)
+	for _, ring := range p.rings {
+		for i, j := 0, len(ring)-2; i < len(ring); j, i = i, i+2 {
+			ax, ay := float64(ring[j]), float64(ring[j+1])
+			dx, dy := float64(ring[i])-ax, float64(ring[i+1])-ay
+			t := 0.0
+			if dx != 0 || dy != 0 {
+				t = max(0, min(1, ((lon-ax)*dx+(lat-ay)*dy)/(dx*dx+dy*dy)))
+			}
+			nearest = min(nearest, math.Hypot(lon-ax-t*dx, lat-ay-t*dy))
+		}
+	}
+	return nearest
+}
+
+// zonePolygons decodes the boundaries once
+var zonePolygons = sync.OnceValue(func() []zonePolygon {
+	polygons, err := decodeZonePolygons(timezonePolygons)
+	if err != nil {
+		panic(fmt.Sprintf("timezone_polygons.bin: %v", err))
+	}
+	return polygons
+})
+
+// polygonZone returns the zone whose polygon contains a point, or else the
+// one nearest to it within nearbyZoneDistance, or "" for points at sea
+func polygonZone(lat, lon float64) string {
+	x, y := lon*polygonScale, lat*polygonScale
+	polygons := zonePolygons()
+	for i := range polygons {
+		if polygons[i].contains(x, y) {
+			return polygons[i].zone
+		}
+	}
+
+	zone, nearest := "", nearbyZoneDistance*polygonScale
+	for i := range polygons {
+		if d := polygons[i].distance(x, y, nearest); d <= nearest {
+			zone, nearest = polygons[i].zone, d
+		}
+	}
+	return zone
+}
+
+func decodeZonePolygons(data []byte) ([]zonePolygon, error) {
+	if len(data) < 4 || string(data[:4]) != "TZP1" {
+		return nil, errors.New("not a timezone polygon file")
+	}
+	r := polygonReader{data: data[4:]}
+	r.string() // boundary release
+
+	var polygons []zonePolygon
+	for zones := r.uvarint(); zones > 0 && r.err == nil; zones-- {
+		name := r.string()
+		for count := r.uvarint(); count > 0 && r.err == nil; count-- {
+			p := zonePolygon{zone: name, minLon: math.Inf(1), minLat: math.Inf(1), maxLon: math.Inf(-1), maxLat: math.Inf(-1)}
+			for rings := r.uvarint(); rings > 0 && r.err == nil; rings-- {
+				points := r.uvarint()
+				if points > uint64(len(r.data)) {
+					return nil, errors.New("truncated ring")
+				}
+				ring := make([]int32, 0, 2*points)
+				var lon, lat int64
+				for ; points > 0 && r.err == nil; points-- {
+					lon += r.varint()
+					lat += r.varint()
+					ring = append(ring, int32(lon), int32(lat))
+				}
+				// Holes lie within the outline, so it alone sets the bounds
+				if len(p.rings) == 0 {
+					for i := 0; i < len(ring); i += 2 {
+						p.minLon, p.maxLon = min(p.minLon, float64(ring[i])), max(p.maxLon, float64(ring[i]))
+						p.minLat, p.maxLat = min(p.minLat, float64(ring[i+1])), max(p.maxLat, float64(ring[i+1]))
+					}
+				}
+				p.rings = append(p.rings, ring)
+			}
+			polygons = append(polygons, p)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return polygons, nil
+}
+
+// polygonReader reads the varints of the polygon file, keeping the first
+// error
+type polygonReader struct {
+	data []byte
+	err  error
+}
+
+func (r *polygonReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *polygonReader) varint() int64 {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *polygonReader) string() string {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.fail()
+		return ""
+	}
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+func (r *polygonReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("truncated timezone polygon file")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestZoneName(t *testing.T) {
+	tests := []struct {
+		place    string
+		lat, lon float64
+		want     string
+	}{
+		{"New York", 40.7334367, -73.5823593, "America/New_York"},
+		{"Chicago", 41.8781, -87.6298, "America/Chicago"},
+		{"Denver", 39.7392, -104.9903, "America/Denver"},
+		{"Phoenix", 33.4484, -112.0740, "America/Phoenix"},
+		{"San Francisco", 37.7749, -122.4194, "America/Los_Angeles"},
+		{"Honolulu", 21.3069, -157.8583, "Pacific/Honolulu"},
+		{"Toronto", 43.6532, -79.3832, "America/Toronto"},
+		{"Vancouver", 49.2827, -123.1207, "America/Vancouver"},
+		{"Mexico City", 19.4326, -99.1332, "America/Mexico_City"},
+		{"Santiago", -33.4489, -70.6693, "America/Santiago"},
+		{"Bariloche", -41.1335, -71.3103, "America/Argentina/Salta"},
+		{"Rio de Janeiro", -22.9068, -43.1729, "America/Sao_Paulo"},
+		{"London", 51.5074, -0.1278, "Europe/London"},
+		{"Lisbon", 38.7223, -9.1393, "Europe/Lisbon"},
+		{"Badajoz", 38.8794, -6.9707, "Europe/Madrid"},
+		{"Paris", 48.8566, 2.3522, "Europe/Paris"},
+		{"Berlin", 52.5200, 13.4050, "Europe/Berlin"},
+		{"Rovaniemi", 66.5039, 25.7294, "Europe/Helsinki"},
+		{"Athens", 37.9838, 23.7275, "Europe/Athens"},
+		{"Istanbul", 41.0082, 28.9784, "Europe/Istanbul"},
+		{"Moscow", 55.7558, 37.6173, "Europe/Moscow"},
+		{"Dubai", 25.2048, 55.2708, "Asia/Dubai"},
+		{"Tehran", 35.6892, 51.3890, "Asia/Tehran"},
+		{"Delhi", 28.6139, 77.2090, "Asia/Kolkata"},
+		{"Kolkata", 22.5726, 88.3639, "Asia/Kolkata"},
+		{"Lahore", 31.5497, 74.3436, "Asia/Karachi"},
+		{"Kathmandu", 27.7172, 85.3240, "Asia/Kathmandu"},
+		{"Yangon", 16.8409, 96.1735, "Asia/Yangon"},
+		{"Chiang Mai", 18.7883, 98.9853, "Asia/Bangkok"},
+		{"Lhasa", 29.6520, 91.1721, "Asia/Shanghai"},
+		{"Beijing", 39.9042, 116.4074, "Asia/Shanghai"},
+		{"Hong Kong", 22.3193, 114.1694, "Asia/Hong_Kong"},
+		{"Seoul", 37.5665, 126.9780, "Asia/Seoul"},
+		{"Tokyo", 35.6762, 139.6503, "Asia/Tokyo"},
+		{"Singapore", 1.3521, 103.8198, "Asia/Singapore"},
+		{"Bali", -8.6500, 115.2167, "Asia/Makassar"},
+		{"Sydney", -33.8688, 151.2093, "Australia/Sydney"},
+		{"Adelaide", -34.9285, 138.6007, "Australia/Adelaide"},
+		{"Perth", -31.9505, 115.8605, "Australia/Perth"},
+		{"Auckland", -36.8485, 174.7633, "Pacific/Auckland"},
+		{"Cairo", 30.0444, 31.2357, "Africa/Cairo"},
+		{"Niamey", 13.5116, 2.1254, "Africa/Niamey"},
+		{"Nairobi", -1.2921, 36.8219, "Africa/Nairobi"},
+		{"Cape Town", -33.9249, 18.4241, "Africa/Johannesburg"},
+		{"Reykjavik", 64.1466, -21.9426, "Atlantic/Reykjavik"},
+		{"Nuuk", 64.1814, -51.6941, "America/Nuuk"},
+		{"Vladivostok", 43.1155, 131.8855, "Asia/Vladivostok"},
+		{"Nakhodka", 42.8240, 132.8929, "Asia/Vladivostok"},
+		{"Tallinn", 59.4370, 24.7536, "Europe/Tallinn"},
+		{"Oslo", 59.9139, 10.7522, "Europe/Oslo"},
+		{"Riga", 56.9496, 24.1052, "Europe/Riga"},
+		{"Stockholm", 59.3293, 18.0686, "Europe/Stockholm"},
+		{"St Petersburg", 59.9311, 30.3609, "Europe/Moscow"},
+		{"Helsinki", 60.1699, 24.9384, "Europe/Helsinki"},
+		{"Irkutsk", 52.2870, 104.3050, "Asia/Irkutsk"},
+		{"Krasnoyarsk", 56.0153, 92.8932, "Asia/Krasnoyarsk"},
+		{"Petropavlovsk-Kamchatsky", 53.0452, 158.6483, "Asia/Kamchatka"},
+		{"Tunis", 36.8065, 10.1815, "Africa/Tunis"},
+		{"Algiers", 36.7538, 3.0588, "Africa/Algiers"},
+		{"Thunder Bay", 48.3809, -89.2477, "America/Toronto"},
+		{"Marquette", 46.5436, -87.3954, "America/Detroit"},
+		{"Gary", 41.5934, -87.3464, "America/Chicago"},
+		{"Aruba", 12.5211, -69.9683, "America/Aruba"},
+		{"Bogota", 4.7110, -74.0721, "America/Bogota"},
+		{"Eucla", -31.6773, 128.8892, "Australia/Eucla"},
+		{"Window Rock", 35.6809, -109.0526, "America/Denver"},
+		{"Tuba City", 36.1355, -111.2399, "America/Denver"},
+		{"Flagstaff", 35.1983, -111.6513, "America/Phoenix"},
+		{"Mid-Atlantic", 30.0, -40.0, "Etc/GMT+3"},
+		{"Indian Ocean", -30.0, 80.0, "Etc/GMT-5"},
+		{"Null Island", 0.0001, 0.0001, "Etc/GMT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.place, func(t *testing.T) {
+			if got := zoneName(tt.lat, tt.lon); got != tt.want {
+				t.Errorf("zoneName(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZonePolygonsLoad(t *testing.T) {
+	polygons := zonePolygons()
+	if len(polygons) == 0 {
+		t.Fatal("zonePolygons() is empty")
+	}
+	for _, p := range polygons {
+		if _, err := loadZone(p.zone); err != nil {
+			t.Errorf("loadZone(%q) error = %v", p.zone, err)
+		}
+	}
+
+	if _, err := decodeZonePolygons(timezonePolygons[:len(timezonePolygons)/2]); err == nil {
+		t.Error("decodeZonePolygons() of a truncated file error = nil")
+	}
+}
+
+func TestPhotoLocation(t *testing.T) {
+	fallback := time.FixedZone("fallback", 3600)
+
+	if got := photoLocation(geoData{}, fallback); got != fallback {
+		t.Errorf("photoLocation() without coordinates = %v, want fallback", got)
+	}
+
+	loc := photoLocation(geoData{Latitude: 40.7334367, Longitude: -73.5823593}, fallback)
+	taken := time.Unix(1496965361, 0).In(loc)
+	if got := taken.Format("2006:01:02 15:04:05 -07:00"); got != "2017:06:08 19:42:41 -04:00" {
+		t.Errorf("photo time in %v = %v, want 2017:06:08 19:42:41 -04:00", loc, got)
+	}
+}
+
+func TestReadZoneRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []zoneRule
+		wantErr bool
+	}{
+		{
+			name:    "folders and dates",
+			content: "# trips\n\nTrip Japan 2019 = Asia/Tokyo\n2019-04-01..2019-04-14 = Asia/Tokyo\n2020-01-05 = America/New_York\nAlbums/a=b = UTC\n",
+			want: []zoneRule{
+				{folder: "Trip Japan 2019"},
+				{from: "2019-04-01", to: "2019-04-14"},
+				{from: "2020-01-05", to: "2020-01-05"},
+				{folder: "Albums/a=b"},
+			},
+		},
+		{name: "missing zone", content: "Trip = \n", wantErr: true},
+		{name: "unknown zone", content: "Trip = Mars/Olympus_Mons\n", wantErr: true},
+		{name: "no separator", content: "Trip Asia/Tokyo\n", wantErr: true},
+		{name: "invalid date", content: "2019-02-30 = Asia/Tokyo\n", wantErr: true},
+		{name: "reversed range", content: "2019-04-14..2019-04-01 = Asia/Tokyo\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "zones.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write rules: %v", err)
+			}
+			got, err := readZoneRules(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readZoneRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("readZoneRules() = %d rules, want %d", len(got), len(tt.want))
+			}
+			for i, r := range got {
+				if r.folder != tt.want[i].folder || r.from != tt.want[i].from || r.to != tt.want[i].to || r.loc == nil {
+					t.Errorf("readZoneRules()[%d] = %+v, want %+v", i, r, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestZoneRulesFallback(t *testing.T) {
+	source := t.TempDir()
+	rulesPath := filepath.Join(source, "zones.txt")
+	rules := "Google Photos/Trip Japan 2019 = Asia/Tokyo\nParis = Europe/Paris\n2019-07-01..2019-07-31 = America/Denver\n"
+	if err := os.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	zones, err := newZoneRules(source, "Australia/Sydney", rulesPath)
+	if err != nil {
+		t.Fatalf("newZoneRules() error = %v", err)
+	}
+
+	june := int64(1559392496)   // 2019-06-01 12:34:56 UTC
+	august := int64(1564617600) // 2019-08-01 00:00:00 UTC, still 31 July in Denver
+	tests := []struct {
+		name      string
+		zones     *zoneRules
+		dir       string
+		timestamp int64
+		want      string
+	}{
+		{"folder", zones, "Google Photos/Trip Japan 2019", june, "Asia/Tokyo"},
+		{"subfolder", zones, "Google Photos/Trip Japan 2019/day 1", june, "Asia/Tokyo"},
+		{"folder name at any depth", zones, "Google Photos/Paris", june, "Europe/Paris"},
+		{"similar folder", zones, "Google Photos/Trip Japan 2019 (2)", june, "Australia/Sydney"},
+		{"date in the rule's zone", zones, "Google Photos/Photos from 2019", august, "America/Denver"},
+		{"folder before dates", zones, "Google Photos/Paris", august, "Europe/Paris"},
+		{"default zone", zones, "Google Photos/Photos from 2019", june, "Australia/Sydney"},
+		{"no rules", nil, "Google Photos/Trip Japan 2019", june, "UTC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonPath := filepath.Join(source, filepath.FromSlash(tt.dir), "IMG_0001.jpg.json")
+			if got := tt.zones.fallback(jsonPath, tt.timestamp, time.UTC); got.String() != tt.want {
+				t.Errorf("fallback(%s) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareUpdate_ZoneRules(t *testing.T) {
+	source := t.TempDir()
+	dir := filepath.Join(source, "Trip Japan 2019")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create album: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.jpg"), []byte("image"), 0644); err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	jsonPath := filepath.Join(dir, "IMG_0001.jpg.json")
+	sidecar := `{"title": "IMG_0001.jpg", "photoTakenTime": {"timestamp": "1559392496"}}`
+	if err := os.WriteFile(jsonPath, []byte(sidecar), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+	rulesPath := filepath.Join(source, "zones.txt")
+	if err := os.WriteFile(rulesPath, []byte("Trip Japan 2019 = Asia/Tokyo\n"), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	zones, err := newZoneRules(source, "", rulesPath)
+	if err != nil {
+		t.Fatalf("newZoneRules() error = %v", err)
+	}
+
+	jobs, err := prepareUpdate(1, jsonPath, newMediaMatcher(nil), zones, nil, nil)
+	if err != nil {
+		t.Fatalf("prepareUpdate() error = %v", err)
+	}
+	tags := jobs[0].tags
+	if tags["DateTimeOriginal"] != "2019:06:01 21:34:56" || tags["OffsetTimeOriginal"] != "+09:00" {
+		t.Errorf("DateTimeOriginal = %q %q, want 2019:06:01 21:34:56 +09:00", tags["DateTimeOriginal"], tags["OffsetTimeOriginal"])
+	}
+}
+
+func TestNewZoneRules(t *testing.T) {
+	if zones, err := newZoneRules(".", "", ""); zones != nil || err != nil {
+		t.Errorf("newZoneRules() = %v, %v; want nil without options", zones, err)
+	}
+	if _, err := newZoneRules(".", "Nowhere/Special", ""); err == nil {
+		t.Error("newZoneRules() error = nil, want an unknown timezone error")
+	}
+	if _, err := newZoneRules(".", "", filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("newZoneRules() error = nil, want a missing rules file error")
+	}
+}
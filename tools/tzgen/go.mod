@@ -0,0 +1,5 @@
+module github.com/bryanbrunetti/exifupdater/tools/tzgen
+
+go 1.24
+
+require github.com/ringsaturn/tzf-rel-lite v0.0.2026-b
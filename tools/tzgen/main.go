@@ -0,0 +1,300 @@
+// Command tzgen builds timezone_polygons.bin, the timezone boundaries
+// exifupdater resolves coordinates with. The boundaries are those of
+// timezone-boundary-builder (https://github.com/evansiroky/timezone-boundary-builder),
+// as packaged by tzf-rel-lite, further simplified to keep the file small.
+// The ocean zones are left out; exifupdater computes those itself.
+//
+// Run it from the repository root with
+//
+//	go generate ./...
+//
+// The file holds, with every count an unsigned varint:
+//
+//	"TZP1"
+//	length and text of the boundary release, e.g. 2026b
+//	number of zones, then for each zone
+//	  length and text of its name
+//	  number of polygons, then for each polygon
+//	    number of rings, the outline first and then any holes, then for each ring
+//	      number of points, then for each point the change in longitude and
+//	      latitude from the previous point of the ring in 1e-4 degrees,
+//	      zigzag encoded
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	tzfrellite "github.com/ringsaturn/tzf-rel-lite"
+)
+
+// scale is the number of coordinate units per degree
+const scale = 1e4
+
+type point struct{ lon, lat float64 }
+
+type polygon [][]point // the outline, then the holes
+
+type zone struct {
+	name     string
+	polygons []polygon
+}
+
+func main() {
+	output := flag.String("o", "timezone_polygons.bin", "file to write")
+	tolerance := flag.Float64("tolerance", 0.01, "simplification tolerance in degrees")
+	flag.Parse()
+
+	version, zones, err := decodeTimezones(tzfrellite.LiteData)
+	if err != nil {
+		log.Fatalf("Error decoding boundaries: %v", err)
+	}
+
+	var kept []zone
+	var before, after int
+	for _, z := range zones {
+		if strings.HasPrefix(z.name, "Etc/") {
+			continue
+		}
+		for i, p := range z.polygons {
+			for j, ring := range p {
+				before += len(ring)
+				z.polygons[i][j] = simplifyRing(ring, *tolerance)
+				after += len(z.polygons[i][j])
+			}
+		}
+		kept = append(kept, z)
+	}
+
+	if err := os.WriteFile(*output, encode(version, kept), 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", *output, err)
+	}
+	fmt.Printf("Wrote %d zones of boundary release %s to %s, %d of %d points kept\n", len(kept), version, *output, after, before)
+}
+
+// simplifyRing drops the points of a closed ring that lie within tolerance
+// of the line through their neighbours. Rings too small to survive keep all
+// their points, so small islands do not vanish.
+func simplifyRing(ring []point, tolerance float64) []point {
+	if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+		ring = ring[:len(ring)-1]
+	}
+	if len(ring) < 4 {
+		return ring
+	}
+
+	// Split the ring at the point farthest from its first one, so both
+	// halves are open lines
+	far := 0
+	for i, p := range ring {
+		if dist(p, ring[0]) > dist(ring[far], ring[0]) {
+			far = i
+		}
+	}
+	first := douglasPeucker(ring[:far+1], tolerance)
+	second := douglasPeucker(append(slices.Clone(ring[far:]), ring[0]), tolerance)
+	simplified := append(first, second[1:len(second)-1]...)
+	if len(simplified) < 4 {
+		return ring
+	}
+	return simplified
+}
+
+func douglasPeucker(line []point, tolerance float64) []point {
+	if len(line) < 3 {
+		return line
+	}
+	index, maxDist := 0, 0.0
+	for i := 1; i < len(line)-1; i++ {
+		if d := segmentDist(line[i], line[0], line[len(line)-1]); d > maxDist {
+			index, maxDist = i, d
+		}
+	}
+	if maxDist <= tolerance {
+		return []point{line[0], line[len(line)-1]}
+	}
+	left := douglasPeucker(line[:index+1], tolerance)
+	right := douglasPeucker(line[index:], tolerance)
+	return append(left[:len(left)-1:len(left)-1], right...)
+}
+
+func dist(a, b point) float64 {
+	return math.Hypot(a.lon-b.lon, a.lat-b.lat)
+}
+
+// segmentDist is the distance from p to the segment from a to b
+func segmentDist(p, a, b point) float64 {
+	dx, dy := b.lon-a.lon, b.lat-a.lat
+	if dx == 0 && dy == 0 {
+		return dist(p, a)
+	}
+	t := ((p.lon-a.lon)*dx + (p.lat-a.lat)*dy) / (dx*dx + dy*dy)
+	t = max(0, min(1, t))
+	return dist(p, point{a.lon + t*dx, a.lat + t*dy})
+}
+
+func encode(version string, zones []zone) []byte {
+	var b bytes.Buffer
+	b.WriteString("TZP1")
+	putString(&b, version)
+	putUvarint(&b, uint64(len(zones)))
+	for _, z := range zones {
+		putString(&b, z.name)
+		putUvarint(&b, uint64(len(z.polygons)))
+		for _, p := range z.polygons {
+			putUvarint(&b, uint64(len(p)))
+			for _, ring := range p {
+				putUvarint(&b, uint64(len(ring)))
+				var lon, lat int64
+				for _, pt := range ring {
+					x, y := int64(math.Round(pt.lon*scale)), int64(math.Round(pt.lat*scale))
+					putVarint(&b, x-lon)
+					putVarint(&b, y-lat)
+					lon, lat = x, y
+				}
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func putString(b *bytes.Buffer, s string) {
+	putUvarint(b, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func putUvarint(b *bytes.Buffer, v uint64) {
+	b.Write(binary.AppendUvarint(nil, v))
+}
+
+func putVarint(b *bytes.Buffer, v int64) {
+	b.Write(binary.AppendVarint(nil, v))
+}
+
+// decodeTimezones reads tzf's Timezones protocol buffer:
+//
+//	message Point     { float lng = 1; float lat = 2; }
+//	message Polygon   { repeated Point points = 1; repeated Polygon holes = 2; }
+//	message Timezone  { repeated Polygon polygons = 1; string name = 2; }
+//	message Timezones { repeated Timezone timezones = 1; bool reduced = 2; string version = 3; }
+func decodeTimezones(data []byte) (string, []zone, error) {
+	var version string
+	var zones []zone
+	err := eachField(data, func(num int, value []byte) error {
+		switch num {
+		case 1:
+			z, err := decodeZone(value)
+			if err != nil {
+				return err
+			}
+			zones = append(zones, z)
+		case 3:
+			version = string(value)
+		}
+		return nil
+	})
+	return version, zones, err
+}
+
+func decodeZone(data []byte) (zone, error) {
+	var z zone
+	err := eachField(data, func(num int, value []byte) error {
+		switch num {
+		case 1:
+			outline, holes, err := decodePolygon(value)
+			if err != nil {
+				return err
+			}
+			z.polygons = append(z.polygons, append(polygon{outline}, holes...))
+		case 2:
+			z.name = string(value)
+		}
+		return nil
+	})
+	return z, err
+}
+
+func decodePolygon(data []byte) ([]point, [][]point, error) {
+	var outline []point
+	var holes [][]point
+	err := eachField(data, func(num int, value []byte) error {
+		switch num {
+		case 1:
+			var p point
+			err := eachField(value, func(num int, value []byte) error {
+				if len(value) != 4 {
+					return errors.New("coordinate is not a float")
+				}
+				f := float64(math.Float32frombits(binary.LittleEndian.Uint32(value)))
+				switch num {
+				case 1:
+					p.lon = f
+				case 2:
+					p.lat = f
+				}
+				return nil
+			})
+			outline = append(outline, p)
+			return err
+		case 2:
+			hole, _, err := decodePolygon(value)
+			holes = append(holes, hole)
+			return err
+		}
+		return nil
+	})
+	return outline, holes, err
+}
+
+// eachField calls fn with the number and payload of each length-delimited
+// or 32-bit field of a message; other fields are skipped
+func eachField(data []byte, fn func(num int, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		num := int(key >> 3)
+		var value []byte
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return errors.New("invalid varint")
+			}
+			data = data[n:]
+			continue
+		case 1:
+			if len(data) < 8 {
+				return errors.New("truncated field")
+			}
+			data = data[8:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errors.New("truncated field")
+			}
+			value, data = data[n:n+int(length)], data[n+int(length):]
+		case 5:
+			if len(data) < 4 {
+				return errors.New("truncated field")
+			}
+			value, data = data[:4], data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
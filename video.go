@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errUnsupportedContainer is returned for files whose container has no date
+// field that can be written
+var errUnsupportedContainer = errors.New("container has no writable date field")
+
+// mediaTags returns the date and location tags for a file taken at taken,
+// in the form its container stores them. Photos get EXIF dates in local
+// time with their offset. QuickTime and MP4 videos get their header dates
+// in UTC, as the format requires, and Apple's creation date key with the
+// offset. Matroska files get their DateUTC element. exiftool writes
+// neither AVI nor WebM, so those files get an errUnsupportedContainer.
+func mediaTags(mediaPath string, gps geoData, taken time.Time) (map[string]string, error) {
+	var tags map[string]string
+	switch ext := strings.ToLower(filepath.Ext(mediaPath)); {
+	case isQuickTimeExtension(ext):
+		tags = quickTimeDateTags(taken)
+	case ext == ".mkv":
+		// Matroska has no standard element for coordinates
+		return map[string]string{matroskaDateTag: taken.UTC().Format("2006:01:02 15:04:05")}, nil
+	case ext == ".avi" || ext == ".webm":
+		return nil, fmt.Errorf("%w: %s", errUnsupportedContainer, ext)
+	default:
+		tags = exifDateTags(taken)
+	}
+	maps.Copy(tags, locationTags(mediaPath, gps, taken))
+	return tags, nil
+}
+
+// exifDateTags returns the EXIF dates of a photo in its local time
+func exifDateTags(taken time.Time) map[string]string {
+	formattedTime := taken.Format("2006:01:02 15:04:05")
+	return map[string]string{
+		"CreateDate":         formattedTime,
+		"DateTimeOriginal":   formattedTime,
+		"OffsetTimeOriginal": taken.Format("-07:00"),
+	}
+}
+
+// quickTimeDateTags returns the movie, track and media header dates of a
+// QuickTime or MP4 video in UTC, and the creation date key Apple Photos
+// reads in local time with its offset. exiftool stores the header dates as
+// given when the QuickTimeUTC option is off, which it is by default.
+func quickTimeDateTags(taken time.Time) map[string]string {
+	utc := taken.UTC().Format("2006:01:02 15:04:05")
+	return map[string]string{
+		"QuickTime:CreateDate":      utc,
+		"QuickTime:TrackCreateDate": utc,
+		"QuickTime:MediaCreateDate": utc,
+		"Keys:CreationDate":         taken.Format("2006:01:02 15:04:05-07:00"),
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMediaTags(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load zone: %v", err)
+	}
+	taken := time.Unix(1496965361, 0).In(newYork) // 2017-06-08 19:42:41 -04:00
+	gps := geoData{Latitude: 40.7334367, Longitude: -73.5823593}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "photo",
+			path: "IMG_0001.jpg",
+			want: map[string]string{
+				"CreateDate":         "2017:06:08 19:42:41",
+				"DateTimeOriginal":   "2017:06:08 19:42:41",
+				"OffsetTimeOriginal": "-04:00",
+				"GPSLatitude":        "40.733437",
+			},
+		},
+		{
+			name: "QuickTime video",
+			path: "IMG_0001.MOV",
+			want: map[string]string{
+				"QuickTime:CreateDate":      "2017:06:08 23:42:41",
+				"QuickTime:TrackCreateDate": "2017:06:08 23:42:41",
+				"QuickTime:MediaCreateDate": "2017:06:08 23:42:41",
+				"Keys:CreationDate":         "2017:06:08 19:42:41-04:00",
+				"Keys:GPSCoordinates":       "40.733437 -73.582359",
+			},
+		},
+		{
+			name: "MP4 video",
+			path: "PXL_20170608_234241.mp4",
+			want: map[string]string{
+				"QuickTime:CreateDate": "2017:06:08 23:42:41",
+				"Keys:CreationDate":    "2017:06:08 19:42:41-04:00",
+			},
+		},
+		{
+			name: "Matroska video",
+			path: "clip.mkv",
+			want: map[string]string{matroskaDateTag: "2017:06:08 23:42:41"},
+		},
+		{name: "AVI video", path: "MVI_0001.AVI", wantErr: true},
+		{name: "WebM video", path: "clip.webm", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mediaTags(tt.path, gps, taken)
+			if tt.wantErr {
+				if !errors.Is(err, errUnsupportedContainer) {
+					t.Errorf("mediaTags() error = %v, want %v", err, errUnsupportedContainer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mediaTags() error = %v", err)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("mediaTags()[%s] = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sidecar names -xmp-naming accepts
+const (
+	xmpNamingExt  = "ext"  // IMG_1234.jpg.xmp, as darktable and digiKam name them
+	xmpNamingStem = "stem" // IMG_1234.xmp, as Lightroom names them
+)
+
+// xmpSidecars writes the metadata of update mode to an XMP sidecar next to
+// each media file and leaves the media files untouched. A nil xmpSidecars
+// writes into the media files instead.
+type xmpSidecars struct {
+	naming string
+	albums albumIndex
+
+	mu      sync.Mutex
+	written map[string][]byte // sidecars of this run, by path
+}
+
+// newXMPSidecars indexes the albums of sourceDir for the sidecars' keywords
+func newXMPSidecars(sourceDir, naming string) (*xmpSidecars, error) {
+	if naming != xmpNamingExt && naming != xmpNamingStem {
+		return nil, fmt.Errorf("unknown XMP sidecar naming %q", naming)
+	}
+	albums, err := buildAlbumIndex(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	return &xmpSidecars{naming: naming, albums: albums, written: make(map[string][]byte)}, nil
+}
+
+// path returns the sidecar of a media file
+func (x *xmpSidecars) path(mediaPath string) string {
+	if x.naming == xmpNamingStem {
+		return strings.TrimSuffix(mediaPath, filepath.Ext(mediaPath)) + ".xmp"
+	}
+	return mediaPath + ".xmp"
+}
+
+// conflict returns why the sidecar of a job must not be written, or "".
+// A sidecar that exists already is kept, as it may hold edits made in a
+// photo manager, unless it holds exactly what would be written. Two media
+// files sharing a stem would share a sidecar under stem naming; only the
+// first gets it unless both would write the same.
+func (x *xmpSidecars) conflict(job updateJob) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if packet, ok := x.written[job.xmpPath]; ok {
+		if bytes.Equal(packet, job.xmp) {
+			return ""
+		}
+		return fmt.Sprintf("XMP sidecar %s is written for another file", filepath.Base(job.xmpPath))
+	}
+
+	existing, err := os.ReadFile(job.xmpPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err.Error()
+	case !bytes.Equal(existing, job.xmp):
+		return fmt.Sprintf("XMP sidecar %s already exists", filepath.Base(job.xmpPath))
+	}
+	x.written[job.xmpPath] = job.xmp
+	return ""
+}
+
+// write writes the sidecars of jobs, returning an error per job
+func (x *xmpSidecars) write(jobs []updateJob) []error {
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		errs[i] = replaceFile(job.xmpPath, job.xmp)
+	}
+	return errs
+}
+
+// xmpPacket renders the sidecar of a media file: the date it was taken in
+// local time with its offset, its location and the description, people
+// and albums of its JSON metadata. People and albums become keywords, flat
+// and under People| and Albums| in the hierarchy Lightroom and darktable
+// read.
+func xmpPacket(meta photoMetadata, taken time.Time, gps geoData, albums []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:exif=\"http://ns.adobe.com/exif/1.0/\"\n")
+	b.WriteString("    xmlns:photoshop=\"http://ns.adobe.com/photoshop/1.0/\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:lr=\"http://ns.adobe.com/lightroom/1.0/\"\n")
+	b.WriteString("    xmlns:Iptc4xmpExt=\"http://iptc.org/std/Iptc4xmpExt/2008-02-29/\"")
+
+	date := taken.Format("2006-01-02T15:04:05-07:00")
+	writeXMPProperty(&b, "exif:DateTimeOriginal", date)
+	writeXMPProperty(&b, "photoshop:DateCreated", date)
+	if gps.Latitude != 0 || gps.Longitude != 0 {
+		writeXMPProperty(&b, "exif:GPSLatitude", xmpCoordinate(gps.Latitude, "N", "S"))
+		writeXMPProperty(&b, "exif:GPSLongitude", xmpCoordinate(gps.Longitude, "E", "W"))
+		if gps.Altitude != 0 {
+			writeXMPProperty(&b, "exif:GPSAltitude", fmt.Sprintf("%d/1000", int64(math.Round(math.Abs(gps.Altitude)*1000))))
+			writeXMPProperty(&b, "exif:GPSAltitudeRef", hemisphere(gps.Altitude, "0", "1"))
+		}
+		writeXMPProperty(&b, "exif:GPSTimeStamp", taken.UTC().Format("2006-01-02T15:04:05Z"))
+		writeXMPProperty(&b, "exif:GPSMapDatum", gpsMapDatum)
+	}
+	b.WriteString(">\n")
+
+	if description := strings.TrimSpace(meta.Description); description != "" {
+		fmt.Fprintf(&b, "   <dc:description>\n    <rdf:Alt>\n     <rdf:li xml:lang=\"x-default\">%s</rdf:li>\n    </rdf:Alt>\n   </dc:description>\n", xmlEscape(description))
+	}
+
+	var people []string
+	for _, person := range meta.People {
+		if name := strings.TrimSpace(person.Name); name != "" && !slices.Contains(people, name) {
+			people = append(people, name)
+		}
+	}
+	var hierarchy []string
+	for _, name := range people {
+		hierarchy = append(hierarchy, "People|"+name)
+	}
+	for _, album := range albums {
+		hierarchy = append(hierarchy, "Albums|"+album)
+	}
+	writeXMPBag(&b, "dc:subject", append(slices.Clone(people), albums...))
+	writeXMPBag(&b, "lr:hierarchicalSubject", hierarchy)
+	writeXMPBag(&b, "Iptc4xmpExt:PersonInImage", people)
+
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>\n")
+	return b.Bytes()
+}
+
+func writeXMPProperty(b *bytes.Buffer, name, value string) {
+	fmt.Fprintf(b, "\n    %s=\"%s\"", name, xmlEscape(value))
+}
+
+// writeXMPBag writes an unordered list, leaving out empty ones
+func writeXMPBag(b *bytes.Buffer, name string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "   <%s>\n    <rdf:Bag>\n", name)
+	for _, value := range values {
+		fmt.Fprintf(b, "     <rdf:li>%s</rdf:li>\n", xmlEscape(value))
+	}
+	fmt.Fprintf(b, "    </rdf:Bag>\n   </%s>\n", name)
+}
+
+// xmpCoordinate formats a coordinate as XMP does: whole degrees, decimal
+// minutes and the hemisphere, e.g. 40,44.006202N
+func xmpCoordinate(value float64, positive, negative string) string {
+	degrees, fraction := math.Modf(math.Abs(value))
+	return fmt.Sprintf("%d,%.6f%s", int(degrees), fraction*60, hemisphere(value, positive, negative))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// albumKey identifies a photo across the folders Takeout copies it into
+type albumKey struct {
+	title     string
+	timestamp string
+}
+
+// albumIndex lists the albums each photo is in
+type albumIndex map[albumKey][]string
+
+// buildAlbumIndex reads the JSON files of every album folder under root,
+// the folders with a metadata.json naming the album
+func buildAlbumIndex(root string) (albumIndex, error) {
+	albums := make(albumIndex)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		album := albumTitle(path)
+		if album == "" {
+			return nil
+		}
+		entries, _ := os.ReadDir(path)
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || filepath.Ext(name) != ".json" || name == "metadata.json" {
+				continue
+			}
+			meta, err := readPhotoMetadata(filepath.Join(path, name))
+			if err != nil {
+				continue
+			}
+			key := albumKey{meta.Title, meta.takenTimestamp()}
+			if !slices.Contains(albums[key], album) {
+				albums[key] = append(albums[key], album)
+			}
+		}
+		return nil
+	})
+	return albums, err
+}
+
+// lookup returns the albums a photo is in
+func (a albumIndex) lookup(meta photoMetadata) []string {
+	return a[albumKey{meta.Title, meta.takenTimestamp()}]
+}
+
+// albumTitle returns the name of the album dir holds, read from its
+// metadata.json, or "" for folders that are not albums
+func albumTitle(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "metadata.json"))
+	if err != nil {
+		return ""
+	}
+	var album struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(data, &album); err != nil {
+		return ""
+	}
+	return album.Title
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXMPPacket(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load zone: %v", err)
+	}
+	taken := time.Unix(1496965361, 0).In(newYork)
+	var meta photoMetadata
+	sidecar := `{"description": "Fish & chips <3", "people": [{"name": "Alice"}, {"name": " Alice "}]}`
+	if err := json.Unmarshal([]byte(sidecar), &meta); err != nil {
+		t.Fatalf("Failed to decode sidecar: %v", err)
+	}
+	gps := geoData{Latitude: 40.7334367, Longitude: -73.5823593, Altitude: -11.199}
+
+	packet := xmpPacket(meta, taken, gps, []string{"Trip NYC"})
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("xmpPacket() is not well-formed XML: %v\n%s", err, packet)
+		}
+	}
+
+	for _, want := range []string{
+		`exif:DateTimeOriginal="2017-06-08T19:42:41-04:00"`,
+		`photoshop:DateCreated="2017-06-08T19:42:41-04:00"`,
+		`exif:GPSLatitude="40,44.006202N"`,
+		`exif:GPSLongitude="73,34.941558W"`,
+		`exif:GPSAltitude="11199/1000"`,
+		`exif:GPSAltitudeRef="1"`,
+		`exif:GPSTimeStamp="2017-06-08T23:42:41Z"`,
+		`<rdf:li xml:lang="x-default">Fish &amp; chips &lt;3</rdf:li>`,
+		`<rdf:li>Albums|Trip NYC</rdf:li>`,
+		`<rdf:li>People|Alice</rdf:li>`,
+		`<Iptc4xmpExt:PersonInImage>`,
+	} {
+		if !bytes.Contains(packet, []byte(want)) {
+			t.Errorf("xmpPacket() is missing %s", want)
+		}
+	}
+	if n := bytes.Count(packet, []byte("<rdf:li>Alice</rdf:li>")); n != 2 {
+		t.Errorf("xmpPacket() lists Alice %d times, want once as a keyword and once as a person", n)
+	}
+	if got := parseXMPDates(packet)["DateTimeOriginal"]; got != "2017-06-08T19:42:41-04:00" {
+		t.Errorf("parseXMPDates(xmpPacket()) = %q, want the date written", got)
+	}
+
+	bare := xmpPacket(photoMetadata{}, taken, geoData{}, nil)
+	for _, unwanted := range []string{"GPS", "dc:description", "dc:subject"} {
+		if bytes.Contains(bare, []byte(unwanted)) {
+			t.Errorf("xmpPacket() without metadata contains %s", unwanted)
+		}
+	}
+}
+
+func TestXMPSidecars_Path(t *testing.T) {
+	tests := []struct {
+		naming string
+		want   string
+	}{
+		{xmpNamingExt, "IMG_1234.HEIC.xmp"},
+		{xmpNamingStem, "IMG_1234.xmp"},
+	}
+	for _, tt := range tests {
+		x := &xmpSidecars{naming: tt.naming}
+		if got := x.path("IMG_1234.HEIC"); got != tt.want {
+			t.Errorf("path(%s) = %v, want %v", tt.naming, got, tt.want)
+		}
+	}
+	if _, err := newXMPSidecars(t.TempDir(), "sidecar"); err == nil {
+		t.Error("newXMPSidecars() error = nil, want an unknown naming error")
+	}
+}
+
+func TestBuildAlbumIndex(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"Trip NYC/metadata.json":             `{"title": "Trip NYC"}`,
+		"Trip NYC/IMG_1234.jpg.json":         `{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`,
+		"Best of 2017/metadata.json":         `{"title": "Best of 2017"}`,
+		"Best of 2017/IMG_1234.jpg.json":     `{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`,
+		"Photos from 2017/IMG_1234.jpg.json": `{"title": "IMG_1234.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`,
+		"Photos from 2017/IMG_1234.jpg":      "image",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	albums, err := buildAlbumIndex(root)
+	if err != nil {
+		t.Fatalf("buildAlbumIndex() error = %v", err)
+	}
+	meta := photoMetadata{Title: "IMG_1234.jpg"}
+	meta.PhotoTakenTime.Timestamp = "1496965361"
+	if got := strings.Join(albums.lookup(meta), ","); got != "Best of 2017,Trip NYC" {
+		t.Errorf("lookup() = %v, want Best of 2017,Trip NYC", got)
+	}
+	meta.PhotoTakenTime.Timestamp = "1496965362"
+	if got := albums.lookup(meta); len(got) != 0 {
+		t.Errorf("lookup() of another photo with the same name = %v, want none", got)
+	}
+}
+
+func TestUpdateWorker_XMPSidecar(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Trip NYC")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create album: %v", err)
+	}
+	files := map[string]string{
+		"metadata.json":     `{"title": "Trip NYC"}`,
+		"MVI_0001.AVI":      "video",
+		"MVI_0001.AVI.json": `{"title": "MVI_0001.AVI", "photoTakenTime": {"timestamp": "1496965361"}, "people": [{"name": "Alice"}]}`,
+		"IMG_0002.jpg":      "image",
+		"IMG_0002.jpg.json": `{"title": "IMG_0002.jpg", "photoTakenTime": {"timestamp": "1496965361"}}`,
+		"IMG_0002.jpg.xmp":  "<x:xmpmeta>edited in darktable</x:xmpmeta>",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	xmp, err := newXMPSidecars(root, xmpNamingExt)
+	if err != nil {
+		t.Fatalf("newXMPSidecars() error = %v", err)
+	}
+	backend := newFakeBackend()
+	opts := updateOptions{keepJSON: true, newBackend: backend.factory(), xmp: xmp}
+	report, updated := runUpdateWorker(opts, nil, filepath.Join(dir, "MVI_0001.AVI.json"), filepath.Join(dir, "IMG_0002.jpg.json"))
+
+	if updated != 1 || backend.writes != 0 {
+		t.Errorf("updated files = %d with %d media writes, want one sidecar and no media writes", updated, backend.writes)
+	}
+	packet, err := os.ReadFile(filepath.Join(dir, "MVI_0001.AVI.xmp"))
+	if err != nil {
+		t.Fatalf("Failed to read the AVI's sidecar: %v", err)
+	}
+	for _, want := range []string{"People|Alice", "Albums|Trip NYC", "exif:DateTimeOriginal="} {
+		if !bytes.Contains(packet, []byte(want)) {
+			t.Errorf("AVI sidecar is missing %s:\n%s", want, packet)
+		}
+	}
+	if existing, _ := os.ReadFile(filepath.Join(dir, "IMG_0002.jpg.xmp")); string(existing) != files["IMG_0002.jpg.xmp"] {
+		t.Errorf("existing sidecar = %q, want it left alone", existing)
+	}
+	if len(report.entries) != 2 {
+		t.Fatalf("report entries = %+v, want one per media file", report.entries)
+	}
+	for _, e := range report.entries {
+		if e.MediaFile == filepath.Join(dir, "IMG_0002.jpg") && (e.Status != statusSkipped || !strings.Contains(e.Detail, "already exists")) {
+			t.Errorf("report entry = %+v, want skipped for its existing sidecar", e)
+		}
+	}
+}